@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/rlr524/greenlight/internal/validator"
+	"log/slog"
+	"net/http"
+)
+
+// getLogLevelHandler() returns the current minimum log level.
+// Method: GET
+// Endpoint: /v1/admin/log-level
+func (app *application) getLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"level": app.logLevel.Level().String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateLogLevelHandler() changes the minimum log level without restarting the application,
+// for example to turn on debug logging during an incident. The change only lasts until the
+// application restarts, after which the level set with the -log-level flag applies again.
+// Method: PUT
+// Endpoint: /v1/admin/log-level
+func (app *application) updateLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Level string `json:"level"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// slog.Level.UnmarshalText() accepts the level names case-insensitively, along with
+	// offsets such as "debug+2".
+	var level slog.Level
+
+	v := validator.New()
+
+	v.Check(input.Level != "", "level", "must be provided")
+	if v.Valid() {
+		v.Check(level.UnmarshalText([]byte(input.Level)) == nil, "level",
+			"must be one of debug, info, warn or error")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	previous := app.logLevel.Level()
+	app.logLevel.Set(level)
+
+	// Log the change at the info level, or at the new level if that is higher, so that the
+	// entry is always written.
+	app.logger.Log(r.Context(), max(level, slog.LevelInfo), "log level changed",
+		"from", previous.String(), "to", level.String(), "user_id", app.contextGetUser(r).ID)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"level": level.String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"github.com/rlr524/greenlight/internal/model"
+	"net/http"
+)
+
+// contextKey is a custom type for request context keys, which avoids collisions with keys
+// set by any third-party packages that also store data in the request context.
+type contextKey string
+
+// userContextKey is the key used for getting and setting user information in the
+// request context, and requestInfoContextKey the key for the requestInfo.
+const (
+	userContextKey        = contextKey("user")
+	requestInfoContextKey = contextKey("requestInfo")
+)
+
+// requestInfo holds details about a request which are only discovered inside the handler
+// chain, but which are needed by middleware further out once the chain has returned. It is
+// stored in the context as a pointer, so that the inner handlers can fill it in.
+type requestInfo struct {
+	// id is the request ID, which is included in every log entry written with the request
+	// context.
+	id string
+	// route is the httprouter pattern which matched the request, such as /v1/movies/:id. It
+	// is empty if no route matched.
+	route string
+	// userID is the ID of the authenticated user, or zero for anonymous requests.
+	userID int64
+}
+
+// The contextSetUser() method returns a new copy of the request with the provided User struct
+// added to the context.
+func (app *application) contextSetUser(r *http.Request, user *model.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// The contextGetUser() method retrieves the User struct from the request context. The only time
+// this helper is used is when we logically expect there to be a User struct value in the
+// context, so if it doesn't exist it will firmly be an "unexpected" error and we panic.
+func (app *application) contextGetUser(r *http.Request) *model.User {
+	user, ok := r.Context().Value(userContextKey).(*model.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
+
+// The contextSetRequestInfo() method returns a new copy of the request with the provided
+// requestInfo added to the context.
+func (app *application) contextSetRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+	return r.WithContext(ctx)
+}
+
+// The contextGetRequestInfo() method retrieves the requestInfo from the request context, or
+// returns nil if there is none.
+func (app *application) contextGetRequestInfo(r *http.Request) *requestInfo {
+	return requestInfoFromContext(r.Context())
+}
+
+// requestInfoFromContext retrieves the requestInfo from a context, or returns nil if there is
+// none. Unlike contextGetRequestInfo() it works on any context, such as the one passed to a
+// slog.Handler.
+func requestInfoFromContext(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoContextKey).(*requestInfo)
+	return info
+}
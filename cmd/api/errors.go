@@ -1,19 +1,30 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/rlr524/greenlight/internal/dal"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
+// statusClientClosedRequest is the non-standard status code (borrowed from nginx) recorded for
+// requests whose client went away before the response could be sent.
+const statusClientClosedRequest = 499
+
 // The logError() method is a generic helper for logging an error message along
-// with the current request method and URL as attributes in the log entry.
+// with the current request method and URL as attributes in the log entry. The request
+// context is passed to the logger, which adds the request ID to the entry.
 func (app *application) logError(r *http.Request, err error) {
 	var (
 		method = r.Method
 		uri    = r.URL.RequestURI()
 	)
 
-	app.logger.Error(err.Error(), "method", method, "uri", uri)
+	app.logger.ErrorContext(r.Context(), err.Error(), "method", method, "uri", uri)
 }
 
 // The errorResponse() method is a generic helper for sending JSON-formatted error messages to the
@@ -33,8 +44,25 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 
 // The serverErrorResponse() method is used when the application encounters an unexpected problem
 // at runtime. It logs the detailed error message, then uses the errorResponse() helper to send
-// a 500 error and JSON response (containing a generic error message) to the client.
+// a 500 error and JSON response (containing a generic error message) to the client. Because
+// every handler passes unexpected data access layer errors to this method, it is also where
+// query timeouts and canceled requests are picked out and handled separately.
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, dal.ErrQueryTimeout):
+		app.queryTimeoutResponse(w, r)
+		return
+	case errors.Is(err, context.Canceled):
+		// The client has gone away, so there is nobody to send a response body to. This isn't
+		// a problem with the application, so it is only logged at the debug level. The status
+		// is still set, so that the metrics and access log record the request as canceled
+		// rather than as the default 200 OK.
+		app.logger.DebugContext(r.Context(), "request canceled by client", "method", r.Method,
+			"uri", r.URL.RequestURI())
+		w.WriteHeader(statusClientClosedRequest)
+		return
+	}
+
 	app.logError(r, err)
 
 	message := "the server encountered a problem and could not process your request"
@@ -74,3 +102,95 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	message := "unable to update the record due to an edit conflict, please try again"
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
+
+// The invalidCredentialsResponse() method is used to write the 401 Unauthorized status when a
+// client provides an email address and password combination which does not match a user.
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid authentication credentials"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// The invalidAuthenticationTokenResponse() method is used to write the 401 Unauthorized status
+// when a client provides a missing, malformed, expired or unknown bearer token. The
+// WWW-Authenticate header lets the client know that a bearer token is expected.
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "invalid or missing authentication token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// The invalidAPIKeyResponse() method is used to write the 401 Unauthorized status when a
+// client provides an X-API-Key header with a malformed, expired or unknown key.
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid API key"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// The authenticationRequiredResponse() method is used to write the 401 Unauthorized status when
+// an anonymous user tries to access an endpoint which requires authentication.
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// The inactiveAccountResponse() method is used to write the 403 Forbidden status when a user
+// who hasn't activated their account tries to access an endpoint which requires activation.
+func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// The notPermittedResponse() method is used to write the 403 Forbidden status when an
+// activated user doesn't have the permission required by an endpoint.
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// The rateLimitExceededResponse() method is used to write the 429 Too Many Requests status when
+// a client has exceeded its rate limit. The Retry-After header tells the client how many
+// seconds to wait before trying again.
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request,
+	retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "rate limit exceeded"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// The quotaExceededResponse() method is used to write the 429 Too Many Requests status when a
+// user or API key has used up its quota for the current window.
+func (app *application) quotaExceededResponse(w http.ResponseWriter, r *http.Request,
+	retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "request quota exceeded"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// The queryTimeoutResponse() method is used to write the 503 Service Unavailable status when a
+// database query doesn't complete within the query timeout. The timeout has already been
+// logged by the data access layer.
+func (app *application) queryTimeoutResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "1")
+
+	message := "the database did not respond in time, please try again"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+// The apiKeyNotAllowedResponse() method is used to write the 403 Forbidden status when a client
+// authenticated with an API key tries to access an endpoint which requires an authentication
+// token.
+func (app *application) apiKeyNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource can't be accessed with an API key, use an authentication token"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// The apiKeyLimitResponse() method is used to write the 403 Forbidden status when a user who
+// already has the maximum number of active API keys asks for another one.
+func (app *application) apiKeyLimitResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("you already have the maximum of %d active API keys",
+		app.config.apiKeyLimit)
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
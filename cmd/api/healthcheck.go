@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"net/http"
 )
 
-// healthcheckHandler() provides health model about the application.
+// livenessHandler() reports that the process is up and able to serve requests. It doesn't
+// check any dependencies, so that an orchestrator doesn't restart the process just because
+// the database is unavailable.
 // Method:  GET
-// Endpoint: /v1/healthcheck
-func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+// Endpoint: /v1/healthcheck/live
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
 	env := envelope{
 		"status": "available",
 		"system_info": map[string]string{
@@ -20,14 +23,94 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
+}
+
+// readinessHandler() reports whether the application is ready to receive traffic. The database
+// must answer a ping within the query timeout and have every migration applied, otherwise a
+// 503 Service Unavailable status is sent along with the status of each check. When every
+// connection in the pool is in use the database checks are skipped rather than run, as they
+// would have to wait for a free connection and time out, taking a busy but healthy instance out
+// of rotation. Errors are logged rather than sent, as the endpoint is public.
+// The original /v1/healthcheck endpoint is kept as an alias of this one.
+// Method:  GET
+// Endpoint: /v1/healthcheck/ready
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), app.config.db.queryTimeout)
+	defer cancel()
+
+	ready := true
+	checks := envelope{}
+
+	// Read the pool statistics before using a connection, so that the checks below don't
+	// count themselves.
+	stats := app.db.Stats()
+	saturated := stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections
+
+	pool := envelope{
+		"status":     "ok",
+		"in_use":     stats.InUse,
+		"idle":       stats.Idle,
+		"max_open":   stats.MaxOpenConnections,
+		"wait_count": stats.WaitCount,
+	}
+	if saturated {
+		pool["status"] = "saturated"
+	}
+	checks["connection_pool"] = pool
+
+	// Connections which are in use show that the database is reachable, so a saturated pool
+	// doesn't fail the check.
+	if saturated {
+		checks["database"] = envelope{"status": "skipped"}
+	} else {
+		database := envelope{"status": "ok"}
+		err := app.db.PingContext(ctx)
+		if err != nil {
+			app.logError(r, err)
+			ready = false
+			database["status"] = "unavailable"
+		}
+		checks["database"] = database
+
+		// The migration status can only be read if the database is reachable.
+		if err == nil {
+			current, dirty, err := app.migrator.Status(ctx)
+			latest := app.migrator.Latest()
+
+			migrations := envelope{"status": "ok", "version": current, "latest": latest}
+			switch {
+			case err != nil:
+				app.logError(r, err)
+				migrations = envelope{"status": "unavailable"}
+			case dirty:
+				migrations["status"] = "dirty"
+			case current < latest:
+				migrations["status"] = "pending"
+			}
+
+			if migrations["status"] != "ok" {
+				ready = false
+			}
+			checks["migrations"] = migrations
+		}
+	}
 
-	//json := `{"status": "available", "environment": %q, "version": %q}`
-	//json = fmt.Sprintf(json, app.config.env, version)
-	//
-	//w.Header().Set("Content-Type", "application/json")
-	//_, err := w.Write([]byte(json))
-	//if err != nil {
-	//	app.logger.Error("There was an error writing the response: %v", err)
-	//	return
-	//}
+	status, code := "available", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+
+	env := envelope{
+		"status": status,
+		"system_info": map[string]string{
+			"environment": app.config.env,
+			"version":     version,
+		},
+		"checks": checks,
+	}
+
+	err := app.writeJSON(w, code, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
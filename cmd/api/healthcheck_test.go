@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rlr524/greenlight/internal/metrics"
+	"github.com/rlr524/greenlight/internal/migrate"
+	"github.com/rlr524/greenlight/internal/quota"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestHealthChecksAreNotLimited(t *testing.T) {
+	app, mock, _ := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.config.limiter.rps = 1
+	app.config.limiter.burst = 2
+	app.config.quota.enabled = true
+	app.config.quota.anonymous = 5
+	app.config.quota.window = time.Hour
+	app.quotas = quota.NewMemoryStore()
+	app.metricsRegistry = metrics.NewRegistry()
+
+	migrator, err := migrate.New(app.db, fstest.MapFS{}, app.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.migrator = migrator
+
+	// routes() can only be called once per test binary, as the metrics() middleware publishes
+	// its expvar variables when the chain is built.
+	routes := app.routes()
+
+	// Twice the anonymous quota, and far more than the rate limiter's burst.
+	probes := 2 * app.config.quota.anonymous
+
+	for range probes {
+		mock.ExpectQuery("SELECT to_regclass").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	}
+
+	for i := range probes {
+		for _, path := range []string{"/v1/healthcheck/live", "/v1/healthcheck/ready"} {
+			w := httptest.NewRecorder()
+			routes.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("probe %d of %s: got status %d; want %d: %s", i+1, path, w.Code,
+					http.StatusOK, w.Body)
+			}
+
+			if w.Header().Get("RateLimit-Limit") != "" {
+				t.Fatalf("probe %d of %s: got RateLimit headers; want none", i+1, path)
+			}
+		}
+	}
+
+	// The probes didn't use any of the quota of the address they came from.
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/unknown", nil))
+
+	if got := w.Header().Get("RateLimit-Remaining"); got != "4" {
+		t.Errorf("got RateLimit-Remaining %q after the probes; want \"4\"", got)
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		saturated      bool
+		exists         bool
+		version        int64
+		dirty          bool
+		statusErr      error
+		wantStatus     int
+		wantDatabase   string
+		wantMigrations string
+	}{
+		{
+			name:           "Ready",
+			exists:         true,
+			version:        1,
+			wantStatus:     http.StatusOK,
+			wantDatabase:   "ok",
+			wantMigrations: "ok",
+		},
+		{
+			name:           "Pending migration",
+			wantStatus:     http.StatusServiceUnavailable,
+			wantDatabase:   "ok",
+			wantMigrations: "pending",
+		},
+		{
+			name:           "Dirty",
+			exists:         true,
+			version:        1,
+			dirty:          true,
+			wantStatus:     http.StatusServiceUnavailable,
+			wantDatabase:   "ok",
+			wantMigrations: "dirty",
+		},
+		{
+			name:           "Migration status error",
+			statusErr:      errors.New(`dial tcp 10.0.0.5:5432: connect: connection refused`),
+			wantStatus:     http.StatusServiceUnavailable,
+			wantDatabase:   "ok",
+			wantMigrations: "unavailable",
+		},
+		{
+			name:         "Saturated pool",
+			saturated:    true,
+			wantStatus:   http.StatusOK,
+			wantDatabase: "skipped",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock, _ := newTestApplication(t)
+
+			migrator, err := migrate.New(app.db, fstest.MapFS{
+				"000001_create_movies.up.sql": {Data: []byte("CREATE TABLE movies ()")},
+			}, app.logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.migrator = migrator
+
+			if tt.saturated {
+				// Hold the only connection in the pool, so that a ping would have to wait
+				// for the query timeout.
+				app.db.SetMaxOpenConns(1)
+
+				conn, err := app.db.Conn(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+			} else {
+				query := mock.ExpectQuery("SELECT to_regclass")
+
+				switch {
+				case tt.statusErr != nil:
+					query.WillReturnError(tt.statusErr)
+				default:
+					query.WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.exists))
+				}
+
+				if tt.exists {
+					mock.ExpectQuery("SELECT version, dirty FROM schema_migrations").
+						WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}).
+							AddRow(tt.version, tt.dirty))
+				}
+			}
+
+			w := httptest.NewRecorder()
+			app.readinessHandler(w, httptest.NewRequest(http.MethodGet, "/v1/healthcheck/ready",
+				nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d; want %d", w.Code, tt.wantStatus)
+			}
+
+			var body struct {
+				Checks map[string]map[string]any `json:"checks"`
+			}
+
+			err = json.Unmarshal(w.Body.Bytes(), &body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := body.Checks["database"]["status"]; got != tt.wantDatabase {
+				t.Errorf("got database status %v; want %q", got, tt.wantDatabase)
+			}
+
+			if got, _ := body.Checks["migrations"]["status"].(string); got != tt.wantMigrations {
+				t.Errorf("got migrations status %q; want %q", got, tt.wantMigrations)
+			}
+
+			// The endpoint is public, so errors mustn't reveal anything about the database.
+			if tt.statusErr != nil && strings.Contains(w.Body.String(), "10.0.0.5") {
+				t.Errorf("the response contains the database error:\n%s", w.Body)
+			}
+		})
+	}
+}
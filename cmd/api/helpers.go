@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
+	"github.com/rlr524/greenlight/internal/validator"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 )
@@ -23,6 +27,17 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// readVersionParam reads the movie version from the "version" URL parameter.
+func (app *application) readVersionParam(r *http.Request) (int32, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	version, err := strconv.ParseInt(params.ByName("version"), 10, 32)
+	if err != nil || version < 1 {
+		return 0, errors.New("invalid version parameter")
+	}
+
+	return int32(version), nil
+}
+
 func (app *application) writeJSON(w http.ResponseWriter, status int,
 	data envelope, headers http.Header) error {
 	js, err := json.MarshalIndent(data, "", "\t")
@@ -143,3 +158,102 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 
 	return nil
 }
+
+// The readString() helper returns a string value from the query string, or the provided
+// default value if no matching key could be found.
+func (app *application) readString(qs url.Values, key string, defaultValue string) string {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	return s
+}
+
+// The readCSV() helper reads a string value from the query string and then splits it into a
+// slice on the comma character. If no matching key could be found, it returns the provided
+// default value.
+func (app *application) readCSV(qs url.Values, key string, defaultValue []string) []string {
+	csv := qs.Get(key)
+
+	if csv == "" {
+		return defaultValue
+	}
+
+	return strings.Split(csv, ",")
+}
+
+// The readInt() helper reads a string value from the query string and converts it to an
+// integer before returning. If no matching key could be found it returns the provided
+// default value. If the value couldn't be converted to an integer, then we record an error
+// message in the provided Validator instance.
+func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+
+	return i
+}
+
+// The background() helper runs the given function in a background goroutine. Panics in the
+// goroutine are recovered and logged, rather than terminating the application as an
+// unrecovered panic in any goroutine other than the request goroutine (which is protected
+// by the recoverPanic() middleware) would. The goroutine is tracked by the application's
+// WaitGroup so that it can be waited for on shutdown. The function is passed a context which
+// carries the values of the given context but isn't canceled along with it, so that handlers
+// can pass r.Context() and have anything the function logs traced back to the request by its
+// ID, without the function being canceled when the request completes. Tasks which don't
+// belong to a request pass context.Background().
+func (app *application) background(ctx context.Context, fn func(ctx context.Context)) {
+	ctx = context.WithoutCancel(ctx)
+
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.ErrorContext(ctx, fmt.Sprintf("%v", err))
+			}
+		}()
+
+		fn(ctx)
+	}()
+}
+
+// The clientIP() helper returns the IP address of the client which made the request. The
+// X-Forwarded-For header can be set to anything by the client, so it is only used when the
+// application is configured to run behind a trusted reverse proxy. Even then, only the
+// right-most entry is used, as that is the one appended by the proxy itself; everything before
+// it (and any other header, such as X-Real-IP) may have come from the client. If that entry
+// isn't a valid IP address, the address of the proxy is used instead.
+func (app *application) clientIP(r *http.Request) string {
+	if app.config.trustedProxy {
+		// The header may be sent more than once, in which case the last one holds the entry
+		// appended by the proxy.
+		if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			entries := strings.Split(values[len(values)-1], ",")
+			ip := strings.TrimSpace(entries[len(entries)-1])
+			if net.ParseIP(ip) != nil {
+				return ip
+			}
+		}
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name         string
+		trustedProxy bool
+		headers      map[string][]string
+		want         string
+	}{
+		{
+			name: "Remote address",
+			want: "192.0.2.1",
+		},
+		{
+			name: "Headers ignored without a trusted proxy",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"203.0.113.7"},
+				"X-Real-Ip":       {"203.0.113.8"},
+			},
+			want: "192.0.2.1",
+		},
+		{
+			name:         "Last X-Forwarded-For entry",
+			trustedProxy: true,
+			headers:      map[string][]string{"X-Forwarded-For": {"198.51.100.9, 203.0.113.7"}},
+			want:         "203.0.113.7",
+		},
+		{
+			name:         "Last X-Forwarded-For header",
+			trustedProxy: true,
+			headers: map[string][]string{
+				"X-Forwarded-For": {"198.51.100.9", "203.0.113.7"},
+			},
+			want: "203.0.113.7",
+		},
+		{
+			name:         "X-Real-IP not trusted",
+			trustedProxy: true,
+			headers:      map[string][]string{"X-Real-Ip": {"203.0.113.8"}},
+			want:         "192.0.2.1",
+		},
+		{
+			name:         "Invalid X-Forwarded-For entry",
+			trustedProxy: true,
+			headers:      map[string][]string{"X-Forwarded-For": {"203.0.113.7, not-an-ip"}},
+			want:         "192.0.2.1",
+		},
+		{
+			name:         "IPv6",
+			trustedProxy: true,
+			headers:      map[string][]string{"X-Forwarded-For": {"2001:db8::1"}},
+			want:         "2001:db8::1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{}
+			app.config.trustedProxy = tt.trustedProxy
+
+			r := httptest.NewRequest("GET", "/v1/movies", nil)
+			r.RemoteAddr = "192.0.2.1:54321"
+			for key, values := range tt.headers {
+				for _, value := range values {
+					r.Header.Add(key, value)
+				}
+			}
+
+			if got := app.clientIP(r); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackground(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctx     func() context.Context
+		fn      func(ctx context.Context)
+		wantLog string
+	}{
+		{
+			name: "Request context",
+			ctx: func() context.Context {
+				return context.WithValue(context.Background(), requestInfoContextKey,
+					&requestInfo{id: "abc123"})
+			},
+			fn: func(ctx context.Context) {
+				panic("sending mail")
+			},
+			wantLog: "request_id=abc123",
+		},
+		{
+			name: "No request",
+			ctx:  context.Background,
+			fn: func(ctx context.Context) {
+				panic("purging movies")
+			},
+			wantLog: "msg=\"purging movies\"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			app := &application{logger: slog.New(contextHandler{slog.NewTextHandler(&buf, nil)})}
+
+			// The function must still run with a live context after the one it was started
+			// with has been canceled, such as when the request has completed.
+			ctx, cancel := context.WithCancel(tt.ctx())
+			started := make(chan struct{})
+			var fnErr error
+
+			app.background(ctx, func(ctx context.Context) {
+				<-started
+				fnErr = ctx.Err()
+				tt.fn(ctx)
+			})
+
+			cancel()
+			close(started)
+			app.wg.Wait()
+
+			if fnErr != nil {
+				t.Errorf("got context error %v; want nil", fnErr)
+			}
+
+			if !strings.Contains(buf.String(), tt.wantLog) {
+				t.Errorf("got log %q; want it to contain %q", buf.String(), tt.wantLog)
+			}
+		})
+	}
+}
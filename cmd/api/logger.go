@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+)
+
+// newLogger returns a logger which writes to stdout in the given format ("json", or "text" for
+// anything else) at the minimum level held by the level variable. The handler is wrapped
+// with a contextHandler, so that log entries written with a request context include the
+// request ID.
+func newLogger(format string, level *slog.LevelVar) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch format {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	default:
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	}
+
+	return slog.New(contextHandler{handler})
+}
+
+// contextHandler is a slog.Handler which adds the request ID from the context to every log
+// entry written with one of the logger's ...Context() methods, such as ErrorContext(), before
+// passing it on to the wrapped handler.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if info := requestInfoFromContext(ctx); info != nil && info.id != "" {
+		record.AddAttrs(slog.String("request_id", info.id))
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs and WithGroup must be overridden so that loggers derived with With() and
+// WithGroup() keep adding the request ID.
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
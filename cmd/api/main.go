@@ -1,40 +1,97 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"expvar"
 	"flag"
-	"fmt"
 	"github.com/joho/godotenv"
 	"github.com/rlr524/greenlight/internal/dal"
+	"github.com/rlr524/greenlight/internal/mailer"
+	"github.com/rlr524/greenlight/internal/metrics"
+	"github.com/rlr524/greenlight/internal/migrate"
+	"github.com/rlr524/greenlight/internal/quota"
+	"github.com/rlr524/greenlight/migrations"
 	"log/slog"
-	"net/http"
 	"os"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 )
 
 const version = "1.0.0"
 
 type config struct {
-	port int
-	env  string
-	db   struct {
+	port            int
+	env             string
+	shutdownTimeout time.Duration
+	logFormat       string
+	db              struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  time.Duration
+		queryTimeout time.Duration
+		autoMigrate  bool
+	}
+	limiter struct {
+		rps     float64
+		burst   int
+		enabled bool
+	}
+	quota struct {
+		enabled   bool
+		store     string
+		window    time.Duration
+		anonymous int
+		free      int
+		partner   int
+	}
+	cors struct {
+		trustedOrigins []string
+	}
+	movies struct {
+		trashRetention time.Duration
+		purgeInterval  time.Duration
+	}
+	trustedProxy bool
+	apiKeyLimit  int
+	smtp         struct {
+		backend   string
+		host      string
+		port      int
+		username  string
+		password  string
+		sender    string
+		outboxDir string
 	}
 }
 
+// The wg field tracks the goroutines started by the background() helper, so that the
+// application can wait for them to complete before it exits. The logLevel field is the
+// minimum level of the logger, which can be changed while the application is running. The db
+// and migrator fields are used directly only by the readiness check.
 type application struct {
 	config           config
 	logger           *slog.Logger
+	logLevel         *slog.LevelVar
+	db               *sql.DB
+	migrator         *migrate.Migrator
 	dataAccessLayers dal.DataAccessLayers
+	mailer           mailer.Mailer
+	quotas           quota.Store
+	metricsRegistry  *metrics.Registry
+	wg               sync.WaitGroup
 }
 
 func main() {
 	var cfg config
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	// The log level is held in a slog.LevelVar, so that it can be changed at runtime. Until the
+	// flags have been parsed, log entries are written with the default text handler.
+	logLevel := new(slog.LevelVar)
+	logger := newLogger("text", logLevel)
 
 	err := godotenv.Load()
 	if err != nil {
@@ -43,10 +100,17 @@ func main() {
 	}
 
 	dbDSN := os.Getenv("DB_DSN")
+	smtpUsername := os.Getenv("SMTP_USERNAME")
+	smtpPassword := os.Getenv("SMTP_PASSWORD")
 
 	flag.IntVar(&cfg.port, "port", 4000, "API Server port")
 	flag.StringVar(&cfg.env, "env", "development",
 		"Environment (development|staging|production)")
+	flag.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 30*time.Second,
+		"Time allowed for in-flight requests and background tasks to complete on shutdown")
+	flag.StringVar(&cfg.logFormat, "log-format", "text", "Log format (text|json)")
+	flag.TextVar(logLevel, "log-level", slog.LevelInfo,
+		"Minimum log level (debug|info|warn|error)")
 	flag.StringVar(&cfg.db.dsn, "db-dsn", dbDSN, "PostgreSQL DSN")
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25,
 		"PostgreSQL max open connections")
@@ -54,9 +118,88 @@ func main() {
 		"PostgreSQL max idle connections")
 	flag.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute,
 		"PostgreSQL max connection idle time")
+	flag.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", 3*time.Second,
+		"PostgreSQL per-query timeout")
+	flag.BoolVar(&cfg.db.autoMigrate, "db-auto-migrate", false,
+		"Apply pending database migrations on startup")
+
+	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2,
+		"Rate limiter maximum requests per second per client")
+	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst per client")
+	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	flag.BoolVar(&cfg.trustedProxy, "trusted-proxy", false,
+		"Identify clients by the last X-Forwarded-For entry, appended by a reverse proxy")
+
+	// Quotas are counted per authenticated user, including requests made with the user's API
+	// keys, or per IP address for anonymous requests, with the limit depending on the user's
+	// plan.
+	flag.BoolVar(&cfg.quota.enabled, "quota-enabled", true, "Enable request quotas")
+	flag.StringVar(&cfg.quota.store, "quota-store", "memory",
+		"Quota store (memory|postgres), use postgres to share quotas between instances")
+	flag.DurationVar(&cfg.quota.window, "quota-window", time.Hour, "Quota window length")
+	flag.IntVar(&cfg.quota.anonymous, "quota-anonymous", 100,
+		"Requests allowed per window for anonymous clients")
+	flag.IntVar(&cfg.quota.free, "quota-free", 1000,
+		"Requests allowed per window on the free plan")
+	flag.IntVar(&cfg.quota.partner, "quota-partner", 10000,
+		"Requests allowed per window on the partner plan")
+	flag.IntVar(&cfg.apiKeyLimit, "api-key-limit", 5, "Maximum number of active API keys per user")
+
+	// Movies stay in the trash, from where they can be restored, for the retention period
+	// before they are purged.
+	flag.DurationVar(&cfg.movies.trashRetention, "movies-trash-retention", 30*24*time.Hour,
+		"Time deleted movies are kept before being purged (0 disables purging)")
+	flag.DurationVar(&cfg.movies.purgeInterval, "movies-purge-interval", time.Hour,
+		"Interval between purges of deleted movies")
+
+	// Use the flag.Func() function to process the -cors-trusted-origins command line flag. In
+	// this we use the strings.Fields() function to split the flag value into a slice based on
+	// whitespace characters and assign it to our config struct. Importantly, if the
+	// -cors-trusted-origins flag is not present, contains the empty string, or contains only
+	// whitespace, then strings.Fields() will return an empty []string slice.
+	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)",
+		func(val string) error {
+			cfg.cors.trustedOrigins = strings.Fields(val)
+			return nil
+		})
+
+	// The outbox backend captures mail locally (in memory, and as .eml files if an outbox
+	// directory is set) instead of sending it, for development and testing.
+	flag.StringVar(&cfg.smtp.backend, "smtp-backend", "smtp", "Mail backend (smtp|outbox)")
+	flag.StringVar(&cfg.smtp.host, "smtp-host", "localhost", "SMTP host")
+	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", smtpUsername, "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", smtpPassword, "SMTP password")
+	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.net>",
+		"SMTP sender")
+	flag.StringVar(&cfg.smtp.outboxDir, "smtp-outbox-dir", "",
+		"Directory the outbox backend writes .eml files to (in memory only if empty)")
 
 	flag.Parse()
 
+	if cfg.logFormat != "text" && cfg.logFormat != "json" {
+		logger.Error("invalid log format", "format", cfg.logFormat)
+		os.Exit(1)
+	}
+	logger = newLogger(cfg.logFormat, logLevel)
+
+	if cfg.movies.trashRetention > 0 && cfg.movies.purgeInterval <= 0 {
+		logger.Error("invalid movies purge interval", "interval", cfg.movies.purgeInterval)
+		os.Exit(1)
+	}
+
+	var transport mailer.Transport
+	switch cfg.smtp.backend {
+	case "smtp":
+		transport = mailer.NewSMTPTransport(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username,
+			cfg.smtp.password)
+	case "outbox":
+		transport = mailer.NewOutbox(cfg.smtp.outboxDir)
+	default:
+		logger.Error("invalid mail backend", "backend", cfg.smtp.backend)
+		os.Exit(1)
+	}
+
 	// The DB connection pool is established and if there is an error, it is
 	// logged, and we exit the application with a code 1 immediately.
 	db, err := openDB(cfg)
@@ -79,24 +222,95 @@ func main() {
 	}(db)
 	logger.Info("database connection pool established")
 
-	app := &application{
-		config:           cfg,
-		logger:           logger,
-		dataAccessLayers: dal.NewDALs(db),
+	// The migrations are embedded in the binary. Running "api migrate ..." applies them and
+	// exits without starting the server; otherwise they are only applied on startup when the
+	// -db-auto-migrate flag is set.
+	migrator, err := migrate.New(db, migrations.FS, logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	if flag.Arg(0) == "migrate" {
+		err = runMigrate(migrator, logger, flag.Args()[1:])
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		return
 	}
 
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
+	if cfg.db.autoMigrate {
+		err = migrator.Up(context.Background())
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		logger.Info("database migrations applied", "version", migrator.Latest())
 	}
 
-	logger.Info("starting server", "addr", srv.Addr, "env", cfg.env)
+	// Publish the application version, the number of active goroutines and the database
+	// connection pool statistics in the expvar handler. The values are calculated every time
+	// the /debug/vars endpoint is requested.
+	expvar.NewString("version").Set(version)
+
+	expvar.Publish("goroutines", expvar.Func(func() any {
+		return runtime.NumGoroutine()
+	}))
+
+	expvar.Publish("database", expvar.Func(func() any {
+		return db.Stats()
+	}))
+
+	// Register the same database connection pool statistics, and the data access layer error
+	// counts, in the Prometheus registry which is served on /metrics.
+	metricsRegistry := metrics.NewRegistry()
+	metricsRegistry.Register(
+		metrics.NewGaugeFunc("greenlight_db_max_open_connections",
+			"Maximum number of open connections to the database.",
+			func() float64 { return float64(db.Stats().MaxOpenConnections) }),
+		metrics.NewGaugeFunc("greenlight_db_open_connections",
+			"Number of established connections to the database, both in use and idle.",
+			func() float64 { return float64(db.Stats().OpenConnections) }),
+		metrics.NewGaugeFunc("greenlight_db_in_use_connections",
+			"Number of database connections currently in use.",
+			func() float64 { return float64(db.Stats().InUse) }),
+		metrics.NewGaugeFunc("greenlight_db_idle_connections",
+			"Number of idle database connections.",
+			func() float64 { return float64(db.Stats().Idle) }),
+		metrics.NewCounterFunc("greenlight_db_wait_count_total",
+			"Total number of connections waited for.",
+			func() float64 { return float64(db.Stats().WaitCount) }),
+		metrics.NewCounterFunc("greenlight_db_wait_duration_seconds_total",
+			"Total time blocked waiting for a new connection.",
+			func() float64 { return db.Stats().WaitDuration.Seconds() }),
+		dal.ErrorsTotal,
+	)
+
+	var quotas quota.Store
+	switch cfg.quota.store {
+	case "memory":
+		quotas = quota.NewMemoryStore()
+	case "postgres":
+		quotas = quota.NewPostgresStore(db, cfg.db.queryTimeout)
+	default:
+		logger.Error("invalid quota store", "store", cfg.quota.store)
+		os.Exit(1)
+	}
+
+	app := &application{
+		config:           cfg,
+		logger:           logger,
+		logLevel:         logLevel,
+		db:               db,
+		migrator:         migrator,
+		dataAccessLayers: dal.NewDALs(db, cfg.db.queryTimeout, logger),
+		mailer:           mailer.New(transport, cfg.smtp.sender),
+		quotas:           quotas,
+		metricsRegistry:  metricsRegistry,
+	}
 
-	err = srv.ListenAndServe()
+	err = app.serve()
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
@@ -1,8 +1,24 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"expvar"
 	"fmt"
+	"github.com/rlr524/greenlight/internal/dal"
+	"github.com/rlr524/greenlight/internal/metrics"
+	"github.com/rlr524/greenlight/internal/model"
+	"github.com/rlr524/greenlight/internal/quota"
+	"github.com/rlr524/greenlight/internal/validator"
+	"golang.org/x/time/rate"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -28,3 +44,524 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// The enableCORS() middleware allows cross-origin requests from the trusted origins set with
+// the -cors-trusted-origins flag. The Access-Control-Allow-Origin header is only ever set to
+// the origin of the request, and only if that origin is trusted; browsers block cross-origin
+// requests from any other origin. Preflight requests are answered straight away.
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Add the "Vary: Origin" header to every response, as the response differs depending
+		// on the origin of the request, and any caches need to know that. The same goes for
+		// the Access-Control-Request-Method header on preflight requests.
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		origin := r.Header.Get("Origin")
+
+		if origin != "" && slices.Contains(app.config.cors.trustedOrigins, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+
+			// A preflight request is an OPTIONS request with an Access-Control-Request-Method
+			// header. Browsers send one before any cross-origin request which isn't "simple",
+			// such as a PATCH or DELETE, or a request with an Authorization header.
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers",
+					"Authorization, Content-Type, X-Expected-Version, X-API-Key")
+
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The rateLimit() middleware limits the number of requests each client IP address can make,
+// using a token bucket per client which refills at limiter-rps tokens per second up to a
+// maximum of limiter-burst tokens.
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	// Define a client struct to hold the rate limiter and last seen time for each client.
+	type client struct {
+		limiter  *rate.Limiter
+		lastSeen time.Time
+	}
+
+	// Declare a mutex and a map to hold the clients' IP addresses and rate limiters.
+	var (
+		mu      sync.Mutex
+		clients = make(map[string]*client)
+	)
+
+	// Launch a background goroutine which removes clients that haven't been seen within the
+	// last three minutes from the map once every minute, so that the map doesn't grow without
+	// bounds. The goroutine lives for as long as the application does, so it isn't tracked
+	// by the background() helper which is waited for on shutdown.
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			mu.Lock()
+
+			for ip, client := range clients {
+				if time.Since(client.lastSeen) > 3*time.Minute {
+					delete(clients, ip)
+				}
+			}
+
+			mu.Unlock()
+		}
+	}()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.limiter.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := app.clientIP(r)
+
+		mu.Lock()
+
+		// Check to see if the IP address already exists in the map. If it doesn't, then
+		// initialize a new rate limiter and add the IP address and limiter to the map.
+		if _, found := clients[ip]; !found {
+			clients[ip] = &client{
+				limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps),
+					app.config.limiter.burst),
+			}
+		}
+
+		clients[ip].lastSeen = time.Now()
+
+		// Reserve a token from the bucket. If the token isn't available straight away, give it
+		// back and tell the client how long it needs to wait for one.
+		reservation := clients[ip].limiter.Reserve()
+
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+			mu.Unlock()
+
+			// The RateLimit headers describe the quota only, so the client is told when to
+			// try again with just the Retry-After header.
+			app.rateLimitExceededResponse(w, r, delay)
+			return
+		}
+
+		// Very importantly, unlock the mutex before calling the next handler in the chain.
+		// Deferring the unlock would mean the mutex isn't unlocked until all the handlers
+		// downstream of this middleware have also returned.
+		mu.Unlock()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The authenticate() middleware extracts a bearer token from the Authorization header (or an
+// API key from the X-API-Key header), looks up the user it belongs to and adds that user to the
+// request context. Requests without either header are given the AnonymousUser, so that later
+// handlers and middleware can always rely on a user being present in the context.
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Add the "Vary: Authorization" and "Vary: X-API-Key" headers to the response. This
+		// indicates to any caches that the response may vary based on the value of these
+		// headers in the request.
+		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
+
+		// Retrieve the value of the Authorization and X-API-Key headers from the request. This
+		// will return the empty string "" if there is no such header found.
+		authorizationHeader := r.Header.Get("Authorization")
+		apiKey := r.Header.Get("X-API-Key")
+
+		if authorizationHeader == "" && apiKey == "" {
+			r = app.contextSetUser(r, model.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var (
+			scope   string
+			token   string
+			invalid func(http.ResponseWriter, *http.Request)
+		)
+
+		if authorizationHeader != "" {
+			// We expect the value of the Authorization header to be in the format
+			// "Bearer <token>". We try to split this into its constituent parts, and if the
+			// header isn't in the expected format we return a 401 Unauthorized response.
+			headerParts := strings.Split(authorizationHeader, " ")
+			if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+				app.rejectCredentials(w, r, app.invalidAuthenticationTokenResponse)
+				return
+			}
+
+			scope, token, invalid = model.ScopeAuthentication, headerParts[1],
+				app.invalidAuthenticationTokenResponse
+		} else {
+			scope, token, invalid = model.ScopeAPIKey, apiKey, app.invalidAPIKeyResponse
+		}
+
+		v := validator.New()
+
+		if model.ValidateTokenPlaintext(v, token); !v.Valid() {
+			app.rejectCredentials(w, r, invalid)
+			return
+		}
+
+		// Retrieve the details of the user associated with the token. Note that GetForToken()
+		// only matches tokens which haven't expired yet.
+		user, err := app.dataAccessLayers.Users.GetForToken(r.Context(), scope, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, dal.ErrRecordNotFound):
+				app.rejectCredentials(w, r, invalid)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = app.contextSetUser(r, user)
+		app.contextGetRequestInfo(r).userID = user.ID
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The rejectCredentials() helper sends the given 401 response for a request with invalid
+// credentials. Such a request is still counted against the anonymous quota of the client IP
+// address, which also sets the RateLimit headers, so that guessing credentials counts towards
+// the quota like any other anonymous request.
+func (app *application) rejectCredentials(w http.ResponseWriter, r *http.Request,
+	respond func(http.ResponseWriter, *http.Request)) {
+	if app.config.quota.enabled {
+		result, ok := app.takeQuota(w, r, "ip:"+app.clientIP(r), app.config.quota.anonymous)
+		if ok && !result.Allowed {
+			app.quotaExceededResponse(w, r, time.Until(result.Reset))
+			return
+		}
+	}
+
+	respond(w, r)
+}
+
+// The enforceQuota() middleware counts each request against a quota for the authenticated user
+// (whether they authenticated with a token or one of their API keys), or for the client IP
+// address for anonymous requests, and rejects the request once the quota for the current
+// window is used up. The size of a user's quota depends on their plan. The state of the quota
+// is reported in the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers of every
+// response. It must run after the authenticate() middleware.
+func (app *application) enforceQuota(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.quota.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user := app.contextGetUser(r)
+
+		var (
+			key   string
+			limit int
+		)
+
+		if user.IsAnonymous() {
+			key, limit = "ip:"+app.clientIP(r), app.config.quota.anonymous
+		} else {
+			key, limit = fmt.Sprintf("user:%d", user.ID), app.quotaLimit(user.Plan)
+		}
+
+		result, ok := app.takeQuota(w, r, key, limit)
+		if ok && !result.Allowed {
+			app.quotaExceededResponse(w, r, time.Until(result.Reset))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The takeQuota() helper counts the request against the quota with the given key and sets the
+// RateLimit headers from the result. A failing quota store shouldn't take the whole API down
+// with it, so if the store returns an error it is logged, no headers are set and ok is false.
+func (app *application) takeQuota(w http.ResponseWriter, r *http.Request, key string,
+	limit int) (result quota.Result, ok bool) {
+	result, err := app.quotas.Take(r.Context(), key, limit, app.config.quota.window)
+	if err != nil {
+		app.logError(r, err)
+		return quota.Result{}, false
+	}
+
+	setRateLimitHeaders(w, result.Limit, result.Remaining, time.Until(result.Reset))
+
+	return result, true
+}
+
+// setRateLimitHeaders sets the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers. The reset time is given in whole seconds, rounded up.
+func setRateLimitHeaders(w http.ResponseWriter, limit, remaining int, resetIn time.Duration) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(resetIn.Seconds()))))
+}
+
+// The authenticatedWithAPIKey() helper reports whether the user in the request context was
+// authenticated with an API key rather than an authentication token. The authenticate()
+// middleware only looks at the X-API-Key header when there is no Authorization header.
+func (app *application) authenticatedWithAPIKey(r *http.Request) bool {
+	return !app.contextGetUser(r).IsAnonymous() && r.Header.Get("Authorization") == "" &&
+		r.Header.Get("X-API-Key") != ""
+}
+
+// The quotaLimit() helper returns the number of requests per window allowed on a plan. Unknown
+// plans get the free plan's quota.
+func (app *application) quotaLimit(plan string) int {
+	switch plan {
+	case model.PlanPartner:
+		return app.config.quota.partner
+	default:
+		return app.config.quota.free
+	}
+}
+
+// The requireAuthenticatedUser() middleware checks that the user in the request context is not
+// the AnonymousUser.
+func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// The requireActivatedUser() middleware checks that the user is both authenticated and has
+// activated their account.
+func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	// Wrap fn with the requireAuthenticatedUser() middleware before returning it, so that
+	// anonymous users are rejected with a 401 before the activation check.
+	return app.requireAuthenticatedUser(fn)
+}
+
+// The requirePermission() middleware checks that the user is activated and has the given
+// permission code. Note that the first parameter is the permission code that we require
+// the user to have.
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		permissions, err := app.dataAccessLayers.Permissions.GetAllForUser(r.Context(), user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	// Wrap this with the requireActivatedUser() middleware before returning it.
+	return app.requireActivatedUser(fn)
+}
+
+// The metricsResponseWriter type wraps an http.ResponseWriter and records the status code and
+// size of the response body, so that they can be read by the metrics() and logRequest()
+// middleware after the handler chain has returned.
+type metricsResponseWriter struct {
+	wrapped       http.ResponseWriter
+	statusCode    int
+	bytesWritten  int
+	headerWritten bool
+}
+
+// newMetricsResponseWriter returns a metricsResponseWriter with the status code initialized to
+// 200, which is the status sent when a handler calls Write() without calling WriteHeader().
+func newMetricsResponseWriter(w http.ResponseWriter) *metricsResponseWriter {
+	return &metricsResponseWriter{
+		wrapped:    w,
+		statusCode: http.StatusOK,
+	}
+}
+
+func (mw *metricsResponseWriter) Header() http.Header {
+	return mw.wrapped.Header()
+}
+
+// WriteHeader passes the status code through to the wrapped http.ResponseWriter and records it,
+// unless the headers have already been written, in which case the status can no longer change.
+func (mw *metricsResponseWriter) WriteHeader(statusCode int) {
+	mw.wrapped.WriteHeader(statusCode)
+
+	if !mw.headerWritten {
+		mw.statusCode = statusCode
+		mw.headerWritten = true
+	}
+}
+
+func (mw *metricsResponseWriter) Write(b []byte) (int, error) {
+	mw.headerWritten = true
+
+	n, err := mw.wrapped.Write(b)
+	mw.bytesWritten += n
+
+	return n, err
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, so that http.ResponseController can reach
+// optional interfaces such as http.Flusher on the underlying writer.
+func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return mw.wrapped
+}
+
+// The metrics() middleware publishes request and response counters in the expvar handler, and
+// records the duration of every request in a Prometheus histogram labelled by route, method
+// and status. It wraps the whole middleware chain, so that every response is counted,
+// including the ones sent by other middleware.
+func (app *application) metrics(next http.Handler) http.Handler {
+	// Initialize the new expvar variables and the histogram when the middleware chain is
+	// first built.
+	var (
+		totalRequestsReceived           = expvar.NewInt("total_requests_received")
+		totalResponsesSent              = expvar.NewInt("total_responses_sent")
+		totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_μs")
+		totalResponsesSentByStatus      = expvar.NewMap("total_responses_sent_by_status")
+
+		requestDuration = metrics.NewHistogramVec("greenlight_http_request_duration_seconds",
+			"Duration of HTTP requests in seconds.", metrics.DefBuckets,
+			"route", "method", "status")
+	)
+
+	app.metricsRegistry.Register(requestDuration)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		totalRequestsReceived.Add(1)
+
+		mw := newMetricsResponseWriter(w)
+
+		next.ServeHTTP(mw, r)
+
+		// On the way back up the middleware chain, increment the number of responses sent by 1
+		// and the count for the status code, and add the processing time for the request.
+		totalResponsesSent.Add(1)
+		totalResponsesSentByStatus.Add(strconv.Itoa(mw.statusCode), 1)
+
+		duration := time.Since(start)
+		totalProcessingTimeMicroseconds.Add(duration.Microseconds())
+
+		route := app.contextGetRequestInfo(r).route
+		if route == "" {
+			route = "unmatched"
+		}
+
+		requestDuration.Observe(duration.Seconds(), route, methodLabel(r.Method),
+			strconv.Itoa(mw.statusCode))
+	})
+}
+
+// methodLabel returns the value of the method label for the request duration histogram. The
+// method is chosen by the client, so anything outside the standard set is recorded as "OTHER"
+// to stop clients from creating an unbounded number of time series.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+// The requestID() middleware identifies each request with an ID, so that everything logged for a
+// request can be correlated, including by the client. An X-Request-ID header sent by the client
+// (or a proxy in front of the application) is used if it looks sensible; otherwise a random ID
+// is generated. The ID is echoed in the X-Request-ID response header. This middleware must
+// wrap all the others, as it adds the requestInfo to the request context which they use.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+
+			// crypto/rand.Read() only fails if the operating system's CSPRNG is broken, in
+			// which case the request can still be served without an ID.
+			_, _ = rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		r = app.contextSetRequestInfo(r, &requestInfo{id: id})
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID reports whether a client-provided request ID is non-empty, at most 128
+// characters long, and only contains letters, digits, and the characters - _ . and :, so that it
+// can be safely logged and echoed back.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// The logRequest() middleware writes one structured access log entry for every request once
+// the response has been sent. The request ID is added to the entry by the logger.
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		mw := newMetricsResponseWriter(w)
+
+		next.ServeHTTP(mw, r)
+
+		info := app.contextGetRequestInfo(r)
+
+		app.logger.InfoContext(r.Context(), "request",
+			"method", r.Method,
+			"route", info.route,
+			"uri", r.URL.RequestURI(),
+			"status", mw.statusCode,
+			"bytes", mw.bytesWritten,
+			"duration", time.Since(start),
+			"user_id", info.userID,
+			"remote_ip", app.clientIP(r),
+		)
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/rlr524/greenlight/internal/quota"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMethodLabel(t *testing.T) {
+	tests := []struct {
+		method string
+		want   string
+	}{
+		{"GET", "GET"},
+		{"DELETE", "DELETE"},
+		{"OPTIONS", "OPTIONS"},
+		{"get", "OTHER"},
+		{"PROPFIND", "OTHER"},
+		{"", "OTHER"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			if got := methodLabel(tt.method); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	app, _, _ := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.config.limiter.rps = 1
+	app.config.limiter.burst = 2
+	app.config.quota.enabled = true
+	app.config.quota.anonymous = 100
+	app.config.quota.window = time.Hour
+	app.quotas = quota.NewMemoryStore()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := app.rateLimit(app.authenticate(app.enforceQuota(next)))
+
+	// The RateLimit headers only ever describe the quota. Requests rejected by the rate
+	// limiter, once the burst is used up, are only given a Retry-After header.
+	tests := []struct {
+		wantStatus     int
+		wantLimit      string
+		wantRemaining  string
+		wantRetryAfter bool
+	}{
+		{http.StatusOK, "100", "99", false},
+		{http.StatusOK, "100", "98", false},
+		{http.StatusTooManyRequests, "", "", true},
+	}
+
+	for i, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/movies", nil))
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("request %d: got status %d; want %d", i+1, w.Code, tt.wantStatus)
+		}
+
+		if got := w.Header().Get("RateLimit-Limit"); got != tt.wantLimit {
+			t.Errorf("request %d: got RateLimit-Limit %q; want %q", i+1, got, tt.wantLimit)
+		}
+
+		if got := w.Header().Get("RateLimit-Remaining"); got != tt.wantRemaining {
+			t.Errorf("request %d: got RateLimit-Remaining %q; want %q", i+1, got,
+				tt.wantRemaining)
+		}
+
+		if got := w.Header().Get("Retry-After") != ""; got != tt.wantRetryAfter {
+			t.Errorf("request %d: got Retry-After %t; want %t", i+1, got, tt.wantRetryAfter)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rlr524/greenlight/internal/migrate"
+	"log/slog"
+	"strconv"
+)
+
+var errMigrateUsage = errors.New("usage: api [flags] migrate up|down|goto N|version|force N")
+
+// The runMigrate() function handles the migrate subcommand, which applies the embedded
+// migrations to the database instead of starting the server. The args are the command line
+// arguments following "migrate".
+func runMigrate(migrator *migrate.Migrator, logger *slog.Logger, args []string) error {
+	if len(args) == 0 {
+		return errMigrateUsage
+	}
+
+	ctx := context.Background()
+
+	// The goto and force commands take a version number, the others take no arguments.
+	var version int64
+	switch args[0] {
+	case "goto", "force":
+		if len(args) != 2 {
+			return errMigrateUsage
+		}
+
+		v, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || v < 0 {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		version = v
+	default:
+		if len(args) != 1 {
+			return errMigrateUsage
+		}
+	}
+
+	switch args[0] {
+	case "up":
+		return migrator.Up(ctx)
+	case "down":
+		return migrator.Down(ctx)
+	case "goto":
+		return migrator.Goto(ctx, version)
+	case "force":
+		return migrator.Force(ctx, version)
+	case "version":
+		current, dirty, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Info("database version", "version", current, "dirty", dirty,
+			"latest", migrator.Latest())
+		return nil
+	default:
+		return errMigrateUsage
+	}
+}
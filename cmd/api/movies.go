@@ -49,7 +49,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Dump the contents of the input struct in an HTTP response.
-	err = app.dataAccessLayers.Movies.Insert(movie)
+	err = app.dataAccessLayers.Movies.Insert(r.Context(), movie, app.contextGetUser(r).ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -74,7 +74,7 @@ func (app *application) getMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.dataAccessLayers.Movies.Get(id)
+	movie, err := app.dataAccessLayers.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, dal.ErrRecordNotFound):
@@ -102,7 +102,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	movie, err := app.dataAccessLayers.Movies.Get(id)
+	movie, err := app.dataAccessLayers.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, dal.ErrRecordNotFound):
@@ -161,7 +161,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Pass the updated movie record to the DAL Update method.
-	err = app.dataAccessLayers.Movies.Update(movie)
+	err = app.dataAccessLayers.Movies.Update(r.Context(), movie, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, dal.ErrEditConflict):
@@ -179,11 +179,119 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 }
 
-// getMoviesHandler fetches all movies that are not flagged as deleted
+// getMoviesHandler fetches all movies that are not flagged as deleted, optionally filtered by
+// title and genres or full-text searched by title via the q parameter, and sorted and
+// paginated via the page, page_size and sort parameters. If the cursor parameter is present
+// (an empty value fetches the first page) keyset pagination is used instead of page numbers,
+// and the metadata contains a next_cursor to pass back for the following page.
 // Method: GET
 // Endpoint: /v1/movies
 func (app *application) getMoviesHandler(w http.ResponseWriter, r *http.Request) {
-	movie, err := app.dataAccessLayers.Movies.GetAll()
+	var input struct {
+		Title  string
+		Query  string
+		Genres []string
+		model.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	// Use the helpers to extract the values from the query string, falling back to
+	// sensible defaults if the client didn't provide them.
+	input.Title = app.readString(qs, "title", "")
+	input.Query = app.readString(qs, "q", "")
+	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	// Search results are sorted by relevance unless the client asks for something else.
+	defaultSort := "id"
+	if input.Query != "" {
+		defaultSort = "relevance"
+	}
+	input.Filters.Sort = app.readString(qs, "sort", defaultSort)
+
+	// Only these values (and their descending counterparts) are accepted for the sort
+	// parameter. Relevance is always sorted with the best match first, so it has no
+	// descending counterpart.
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "relevance",
+		"-id", "-title", "-year", "-runtime"}
+
+	model.ValidateFilters(v, input.Filters)
+	v.Check(input.Filters.Sort != "relevance" || input.Query != "", "sort",
+		"relevance can only be used together with the q parameter")
+
+	if qs.Has("cursor") {
+		v.Check(!qs.Has("page"), "page", "must not be used together with cursor")
+		v.Check(input.Filters.Sort != "relevance", "sort",
+			"relevance can not be used together with cursor")
+
+		// An empty cursor requests the first page.
+		var cursor *model.Cursor
+		if qs.Get("cursor") != "" {
+			var err error
+			cursor, err = model.DecodeCursor(qs.Get("cursor"))
+			if err != nil {
+				v.AddError("cursor", "invalid cursor")
+			} else if v.Valid() {
+				// The sort column is only safe to look up once the filters are valid.
+				model.ValidateCursor(v, cursor, input.Filters, "title")
+			}
+		}
+
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		movies, metadata, err := app.dataAccessLayers.Movies.GetAllByCursor(r.Context(), input.Title,
+			input.Query, input.Genres, input.Filters, cursor)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// An empty result is not an error; it is returned as an empty movies array together with
+	// an empty metadata object.
+	movies, metadata, err := app.dataAccessLayers.Movies.GetAll(r.Context(), input.Title, input.Query,
+		input.Genres, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteMovieHandler moves a single movie to the trash, by setting its deleted flag to true. A
+// movie which is already in the trash is reported as not found.
+// Method: DELETE
+// Endpoint: /v1/movies/:id
+func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.dataAccessLayers.Movies.Delete(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, dal.ErrRecordNotFound):
@@ -194,24 +302,26 @@ func (app *application) getMoviesHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"},
+		nil)
 	if err != nil {
-		app.logger.Error(err.Error())
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-// deleteMovieHandler updates the deleted flag on a single movie to true
+// hardDeleteMovieHandler permanently deletes a single movie along with its revisions, whether
+// or not it is in the trash. It is only available to admins, via the hard parameter of the
+// delete endpoint.
 // Method: DELETE
-// Endpoint: /v1/movies/:id
-func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
+// Endpoint: /v1/movies/:id?hard=true
+func (app *application) hardDeleteMovieHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
 		return
 	}
 
-	err = app.dataAccessLayers.Movies.Delete(id)
+	err = app.dataAccessLayers.Movies.HardDelete(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, dal.ErrRecordNotFound):
@@ -222,8 +332,68 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"},
-		nil)
+	err = app.writeJSON(w, http.StatusOK,
+		envelope{"message": "movie successfully deleted permanently"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreMovieHandler takes a single movie back out of the trash.
+// Method: POST
+// Endpoint: /v1/movies/:id/restore
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.dataAccessLayers.Movies.Restore(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, dal.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getDeletedMoviesHandler lists the movies in the trash, most recently deleted first unless
+// asked otherwise, paginated via the page, page_size and sort parameters.
+// Method: GET
+// Endpoint: /v1/movies/trash
+func (app *application) getDeletedMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := model.Filters{
+		Page:     app.readInt(qs, "page", 1, v),
+		PageSize: app.readInt(qs, "page_size", 20, v),
+		Sort:     app.readString(qs, "sort", "-deleted_at"),
+		SortSafelist: []string{"id", "title", "year", "runtime", "deleted_at",
+			"-id", "-title", "-year", "-runtime", "-deleted_at"},
+	}
+
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.dataAccessLayers.Movies.GetAllDeleted(r.Context(), filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// The purgeDeletedMovies() method permanently deletes movies which have been in the trash for
+// longer than the retention period, once every purge interval, until the stop channel is
+// closed. Errors are logged and the purge is tried again at the next interval. It is run with
+// the background() helper.
+func (app *application) purgeDeletedMovies(ctx context.Context, stop <-chan struct{}) {
+	ticker := time.NewTicker(app.config.movies.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		purged, err := app.dataAccessLayers.Movies.PurgeDeleted(ctx,
+			app.config.movies.trashRetention)
+		if err != nil {
+			app.logger.ErrorContext(ctx, "purging deleted movies", "error", err.Error())
+			continue
+		}
+
+		if purged > 0 {
+			app.logger.InfoContext(ctx, "purged deleted movies", "count", purged,
+				"retention", app.config.movies.trashRetention)
+		}
+	}
+}
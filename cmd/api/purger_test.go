@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"testing"
+	"time"
+)
+
+func TestPurgeDeletedMovies(t *testing.T) {
+	app, mock, _ := newTestApplication(t)
+	app.config.movies.trashRetention = 24 * time.Hour
+	app.config.movies.purgeInterval = 10 * time.Millisecond
+
+	// A failed purge is retried at the next interval, rather than stopping the purger.
+	mock.ExpectExec("DELETE FROM movies WHERE deleted = true").
+		WithArgs(float64(24 * 60 * 60)).
+		WillReturnError(errors.New("connection refused"))
+	mock.ExpectExec("DELETE FROM movies WHERE deleted = true").
+		WithArgs(float64(24 * 60 * 60)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	app.background(context.Background(), func(ctx context.Context) {
+		app.purgeDeletedMovies(ctx, stop)
+	})
+
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for mock.ExpectationsWereMet() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the purger didn't run twice within a second")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	close(stop)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the purger didn't stop")
+	}
+}
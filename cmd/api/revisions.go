@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"github.com/rlr524/greenlight/internal/dal"
+	"github.com/rlr524/greenlight/internal/model"
+	"github.com/rlr524/greenlight/internal/validator"
+	"net/http"
+	"strconv"
+)
+
+// getMovieRevisionsHandler() lists the recorded versions of a movie, newest first unless the
+// sort parameter is "version", paginated via the page and page_size parameters.
+// Method: GET
+// Endpoint: /v1/movies/:id/revisions
+func (app *application) getMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := model.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "-version"),
+		SortSafelist: []string{"version", "-version"},
+	}
+
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The revisions of deleted movies are not available, just like the movies themselves.
+	_, err = app.dataAccessLayers.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, dal.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	revisions, metadata, err := app.dataAccessLayers.MovieRevisions.GetAllForMovie(r.Context(),
+		id, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"revisions": revisions, "metadata": metadata},
+		nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getMovieRevisionHandler() retrieves a single version of a movie.
+// Method: GET
+// Endpoint: /v1/movies/:id/revisions/:version
+func (app *application) getMovieRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	version, err := app.readVersionParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// The revisions of deleted movies are not available, just like the movies themselves.
+	_, err = app.dataAccessLayers.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, dal.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	revision, err := app.dataAccessLayers.MovieRevisions.Get(r.Context(), id, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, dal.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"revision": revision}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// diffMovieRevisionsHandler() lists the fields which differ between two versions of a movie,
+// given by the from and to parameters. The to parameter defaults to the current version. The
+// versions of a movie from before revisions were recorded can't be compared, and are reported
+// as not found.
+// Method: GET
+// Endpoint: /v1/movies/:id/diff
+func (app *application) diffMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.dataAccessLayers.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, dal.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	from := app.readInt(qs, "from", 0, v)
+	to := app.readInt(qs, "to", int(movie.Version), v)
+
+	v.Check(qs.Has("from"), "from", "must be provided")
+	v.Check(from >= 1 && from <= int(movie.Version), "from",
+		"must be an existing version of the movie")
+	v.Check(to >= 1 && to <= int(movie.Version), "to", "must be an existing version of the movie")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var revisions [2]*model.MovieRevision
+	for i, version := range []int{from, to} {
+		revisions[i], err = app.dataAccessLayers.MovieRevisions.Get(r.Context(), id,
+			int32(version))
+		if err != nil {
+			switch {
+			case errors.Is(err, dal.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	diff := envelope{
+		"from":    from,
+		"to":      to,
+		"changes": model.DiffMovieSnapshots(revisions[0].Snapshot, revisions[1].Snapshot),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"diff": diff}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revertMovieHandler() restores the fields of a movie from one of its earlier versions. The
+// restored values are saved as a new version, so the revert itself shows up in the revision
+// history and can be undone in the same way. Like updateMovieHandler(), it honours the
+// X-Expected-Version header and responds with 409 Conflict if the movie has been changed
+// in the meantime.
+// Method: POST
+// Endpoint: /v1/movies/:id/revert
+func (app *application) revertMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Version int32 `json:"version"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	movie, err := app.dataAccessLayers.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, dal.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if r.Header.Get("X-Expected-Version") != "" {
+		if strconv.Itoa(int(movie.Version)) != r.Header.Get("X-Expected-Version") {
+			app.editConflictResponse(w, r)
+			return
+		}
+	}
+
+	v := validator.New()
+
+	v.Check(input.Version >= 1 && input.Version <= movie.Version, "version",
+		"must be an existing version of the movie")
+	v.Check(input.Version != movie.Version, "version", "must not be the current version")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revision, err := app.dataAccessLayers.MovieRevisions.Get(r.Context(), id, input.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, dal.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movie.Title = revision.Snapshot.Title
+	movie.Year = revision.Snapshot.Year
+	movie.Runtime = revision.Snapshot.Runtime
+	movie.Genres = revision.Snapshot.Genres
+
+	// The validation rules may have changed since the version was saved, so the restored
+	// values are checked again before they are saved.
+	if model.ValidateMovie(v, movie); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The update is made against the version read above, so a change made by someone else
+	// since then results in an edit conflict.
+	err = app.dataAccessLayers.Movies.Update(r.Context(), movie, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, dal.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"expvar"
 	"github.com/julienschmidt/httprouter"
+	"github.com/rlr524/greenlight/internal/model"
+	"github.com/rlr524/greenlight/internal/validator"
 	"net/http"
+	"strconv"
 )
 
 func (app *application) routes() http.Handler {
-	// Initialize a new httprouter instance
-	r := httprouter.New()
+	// Initialize a new httprouter instance, wrapped so that the route pattern of every handler
+	// is recorded for the metrics.
+	r := router{Router: httprouter.New(), app: app}
 	v := "/v1"
 
 	r.NotFound = http.HandlerFunc(app.notFoundResponse)
@@ -15,12 +20,156 @@ func (app *application) routes() http.Handler {
 
 	// Register the relevant methods, URL patterns and handler functions for the endpoints
 	// using the HandlerFunc() method.
-	r.HandlerFunc(http.MethodGet, v+"/healthcheck", app.healthcheckHandler)
-	r.HandlerFunc(http.MethodGet, v+"/movies", app.getMoviesHandler)
-	r.HandlerFunc(http.MethodPost, v+"/movies", app.createMovieHandler)
-	r.HandlerFunc(http.MethodGet, v+"/movies/:id", app.getMovieHandler)
-	r.HandlerFunc(http.MethodPatch, v+"/movies/:id", app.updateMovieHandler)
-	r.HandlerFunc(http.MethodDelete, v+"/movies/:id", app.deleteMovieHandler)
-
-	return app.recoverPanic(r)
+	r.HandlerFunc(http.MethodGet, v+"/healthcheck", app.readinessHandler)
+	r.HandlerFunc(http.MethodGet, v+"/healthcheck/live", app.livenessHandler)
+	r.HandlerFunc(http.MethodGet, v+"/healthcheck/ready", app.readinessHandler)
+	r.HandlerFunc(http.MethodGet, v+"/movies",
+		app.requirePermission(model.PermissionMoviesRead, app.getMoviesHandler))
+	r.HandlerFunc(http.MethodPost, v+"/movies",
+		app.requirePermission(model.PermissionMoviesWrite, app.createMovieHandler))
+	r.HandlerFunc(http.MethodGet, v+"/movies/:id", app.dispatchMovieTrash(
+		app.requirePermission(model.PermissionMoviesWrite, app.getDeletedMoviesHandler),
+		app.requirePermission(model.PermissionMoviesRead, app.getMovieHandler)))
+	r.HandlerFunc(http.MethodPatch, v+"/movies/:id",
+		app.requirePermission(model.PermissionMoviesWrite, app.updateMovieHandler))
+	r.HandlerFunc(http.MethodDelete, v+"/movies/:id", app.dispatchHardDelete(
+		app.requirePermission(model.PermissionAdmin, app.hardDeleteMovieHandler),
+		app.requirePermission(model.PermissionMoviesWrite, app.deleteMovieHandler)))
+	r.HandlerFunc(http.MethodPost, v+"/movies/:id/restore",
+		app.requirePermission(model.PermissionMoviesWrite, app.restoreMovieHandler))
+	r.HandlerFunc(http.MethodGet, v+"/movies/:id/revisions",
+		app.requirePermission(model.PermissionMoviesRead, app.getMovieRevisionsHandler))
+	r.HandlerFunc(http.MethodGet, v+"/movies/:id/revisions/:version",
+		app.requirePermission(model.PermissionMoviesRead, app.getMovieRevisionHandler))
+	r.HandlerFunc(http.MethodGet, v+"/movies/:id/diff",
+		app.requirePermission(model.PermissionMoviesRead, app.diffMovieRevisionsHandler))
+	r.HandlerFunc(http.MethodPost, v+"/movies/:id/revert",
+		app.requirePermission(model.PermissionMoviesWrite, app.revertMovieHandler))
+
+	r.HandlerFunc(http.MethodPost, v+"/users", app.registerUserHandler)
+	r.HandlerFunc(http.MethodPut, v+"/users/activated", app.activateUserHandler)
+	r.HandlerFunc(http.MethodPut, v+"/users/password", app.updateUserPasswordHandler)
+
+	r.HandlerFunc(http.MethodPost, v+"/tokens/authentication", app.createAuthenticationTokenHandler)
+	r.HandlerFunc(http.MethodPost, v+"/tokens/password-reset", app.createPasswordResetTokenHandler)
+	r.HandlerFunc(http.MethodPost, v+"/tokens/activation", app.createActivationTokenHandler)
+	r.HandlerFunc(http.MethodPost, v+"/tokens/api-key",
+		app.requireActivatedUser(app.createAPIKeyHandler))
+
+	r.HandlerFunc(http.MethodGet, v+"/admin/log-level",
+		app.requirePermission(model.PermissionAdmin, app.getLogLevelHandler))
+	r.HandlerFunc(http.MethodPut, v+"/admin/log-level",
+		app.requirePermission(model.PermissionAdmin, app.updateLogLevelHandler))
+
+	// The expvar output includes the command line the application was started with, which may
+	// contain secrets such as the database DSN, so both metrics endpoints are admin only.
+	// Prometheus can authenticate with an admin user's token or API key.
+	r.HandlerFunc(http.MethodGet, "/debug/vars",
+		app.requirePermission(model.PermissionAdmin, expvar.Handler().ServeHTTP))
+	r.HandlerFunc(http.MethodGet, "/metrics",
+		app.requirePermission(model.PermissionAdmin, app.metricsRegistry.Handler().ServeHTTP))
+
+	// The authenticate() middleware runs for every request, so that every handler can retrieve
+	// the current user (or the AnonymousUser) from the request context. Rate limiting happens
+	// before authentication, so that rate limited clients don't cause any database lookups,
+	// while quotas depend on the user and so are enforced after authentication. CORS comes
+	// first, so that preflight requests are answered without counting against any limits.
+	// Health checks skip the limits altogether (see dispatchHealthChecks()). The metrics() and
+	// logRequest() middleware wrap everything else, so that every response is counted and
+	// logged. The requestID() middleware comes first of all, as it adds the request ID to the
+	// context.
+	limited := app.rateLimit(app.authenticate(app.enforceQuota(r)))
+
+	return app.requestID(app.logRequest(app.metrics(app.recoverPanic(app.enableCORS(
+		app.dispatchHealthChecks(r, limited))))))
+}
+
+// dispatchHealthChecks sends GET requests for the health check endpoints straight to the
+// router, and every other request through the rate limiter, authentication and quotas.
+// Orchestrators probe these endpoints every few seconds from the same address and without
+// credentials, so the probes would soon use up the anonymous quota and have a healthy instance
+// taken out of rotation. The health checks don't depend on the user, so they don't need the
+// authenticate() middleware either.
+func (app *application) dispatchHealthChecks(health, limited http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			switch r.URL.Path {
+			case "/v1/healthcheck", "/v1/healthcheck/live", "/v1/healthcheck/ready":
+				health.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		limited.ServeHTTP(w, r)
+	})
+}
+
+// router wraps httprouter.Router so that every registered handler records the URL pattern it
+// was registered with (such as /v1/movies/:id) in the requestInfo in the request context. This
+// lets the metrics be labelled by route rather than by raw path, which would give every movie
+// ID a series of its own.
+type router struct {
+	*httprouter.Router
+	app *application
+}
+
+func (rt router) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	rt.Handler(method, path, handler)
+}
+
+func (rt router) Handler(method, path string, handler http.Handler) {
+	rt.Router.Handler(method, path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info := rt.app.contextGetRequestInfo(r); info != nil {
+			info.route = path
+		}
+
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+// dispatchMovieTrash sends requests for /v1/movies/trash to the trash handler and every other
+// request to the movie handler. It is needed because httprouter doesn't allow a static path
+// segment in the same position as a named parameter, so the trash listing has to be routed
+// through /v1/movies/:id. The route recorded for the metrics is corrected to match.
+func (app *application) dispatchMovieTrash(trash, movie http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if httprouter.ParamsFromContext(r.Context()).ByName("id") != "trash" {
+			movie(w, r)
+			return
+		}
+
+		if info := app.contextGetRequestInfo(r); info != nil {
+			info.route = "/v1/movies/trash"
+		}
+
+		trash(w, r)
+	}
+}
+
+// dispatchHardDelete sends delete requests with the hard parameter set to true to the hard
+// delete handler, which requires a permission of its own, and every other delete request to
+// the soft delete handler.
+func (app *application) dispatchHardDelete(hard, soft http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		qs := r.URL.Query()
+		if !qs.Has("hard") {
+			soft(w, r)
+			return
+		}
+
+		isHard, err := strconv.ParseBool(qs.Get("hard"))
+		if err != nil {
+			v := validator.New()
+			v.AddError("hard", "must be a boolean value")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		if isHard {
+			hard(w, r)
+			return
+		}
+
+		soft(w, r)
+	}
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// The serve() method starts the HTTP server and blocks until it has been shut down. When the
+// process receives a SIGINT or SIGTERM signal the server stops accepting new connections, and
+// in-flight requests and then background tasks are given until the shutdown timeout to
+// complete. A clean stop returns nil; an error is returned if the server fails to start or
+// if the graceful shutdown could not complete in time.
+func (app *application) serve() error {
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.config.port),
+		Handler:      app.routes(),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+
+	// The shutdownError channel receives the outcome of the graceful shutdown.
+	shutdownError := make(chan error)
+
+	// The purger runs until stopPurger is closed on shutdown. It is started with the
+	// background() helper, so that a purge which is in progress is allowed to complete and a
+	// panic in the purger is logged rather than crashing the application.
+	stopPurger := make(chan struct{})
+	if app.config.movies.trashRetention > 0 {
+		app.background(context.Background(), func(ctx context.Context) {
+			app.purgeDeletedMovies(ctx, stopPurger)
+		})
+	}
+
+	go func() {
+		// signal.Notify() doesn't block when sending to the channel, so it must be buffered
+		// to make sure the signal isn't missed.
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+		// Read the signal from the quit channel. This code will block until a signal
+		// is received.
+		s := <-quit
+
+		app.logger.Info("shutting down server", "signal", s.String())
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+		defer cancel()
+
+		// Shutdown() makes ListenAndServe() return http.ErrServerClosed immediately, and then
+		// waits for in-flight requests to complete (or the context to time out).
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			shutdownError <- fmt.Errorf("shutting down server: %w", err)
+			return
+		}
+
+		close(stopPurger)
+
+		app.logger.Info("completing background tasks", "addr", srv.Addr)
+
+		// Wait for the background goroutines to complete within whatever is left of the
+		// shutdown timeout.
+		done := make(chan struct{})
+		go func() {
+			app.wg.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			shutdownError <- nil
+		case <-ctx.Done():
+			shutdownError <- fmt.Errorf("completing background tasks: %w", ctx.Err())
+		}
+	}()
+
+	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env)
+
+	// Any error other than http.ErrServerClosed means the server failed to start or stopped
+	// unexpectedly, so it is returned straight away.
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("stopped server", "addr", srv.Addr)
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rlr524/greenlight/internal/dal"
+	"github.com/rlr524/greenlight/internal/mailer"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestApplication returns an application backed by a sqlmock database and an in-memory mail
+// outbox, along with the mock to set expectations on and the outbox to inspect the mail sent.
+// The test fails if any expected query isn't run.
+func newTestApplication(t *testing.T) (*application, sqlmock.Sqlmock, *mailer.Outbox) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	outbox := mailer.NewOutbox("")
+
+	app := &application{
+		logger:           logger,
+		db:               db,
+		dataAccessLayers: dal.NewDALs(db, time.Second, logger),
+		mailer:           mailer.New(outbox, "Greenlight <no-reply@greenlight.net>"),
+	}
+
+	app.config.db.queryTimeout = time.Second
+
+	return app, mock, outbox
+}
+
+// serve sends a request with the given JSON body to the handler, waits for any background
+// tasks it started to complete and returns the response.
+func serve(app *application, handler http.HandlerFunc, method, body string) *http.Response {
+	r := httptest.NewRequest(method, "/", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	handler(w, r)
+	app.wg.Wait()
+
+	return w.Result()
+}
+
+// tokenHash is a sqlmock argument matcher which matches the hash of a token, and keeps it so
+// that it can be compared with the plaintext token sent to the user.
+type tokenHash struct {
+	hash []byte
+}
+
+func (th *tokenHash) Match(v driver.Value) bool {
+	b, ok := v.([]byte)
+	th.hash = b
+	return ok && len(b) == sha256.Size
+}
+
+var tokenRX = regexp.MustCompile(`"token": "([A-Z2-7]{26})"`)
+
+// checkTokenMail checks that the message contains a plaintext token whose hash is hash.
+func checkTokenMail(t *testing.T, msg mailer.Message, hash []byte) {
+	t.Helper()
+
+	matches := tokenRX.FindStringSubmatch(msg.PlainBody)
+	if matches == nil {
+		t.Fatalf("no token in message body:\n%s", msg.PlainBody)
+	}
+
+	sum := sha256.Sum256([]byte(matches[1]))
+	if !bytes.Equal(sum[:], hash) {
+		t.Error("the token sent by mail doesn't match the token stored")
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/rlr524/greenlight/internal/dal"
+	"github.com/rlr524/greenlight/internal/model"
+	"github.com/rlr524/greenlight/internal/validator"
+	"net/http"
+	"time"
+)
+
+// createAuthenticationTokenHandler() exchanges an email address and password for a stateful
+// authentication token which is valid for 24 hours.
+// Method: POST
+// Endpoint: /v1/tokens/authentication
+func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	model.ValidateEmail(v, input.Email)
+	model.ValidatePasswordPlaintext(v, input.Password)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Look up the user record based on the email address. If no matching user was found, then
+	// we call the invalidCredentialsResponse() helper to send a 401 Unauthorized response.
+	user, err := app.dataAccessLayers.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, dal.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	token, err := app.dataAccessLayers.Tokens.New(r.Context(), user.ID,
+		24*time.Hour, model.ScopeAuthentication)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPasswordResetTokenHandler() issues a short-lived password reset token for the user
+// with the given email address. The same response is sent whether or not the email address
+// belongs to an activated user, so the endpoint can't be used to find out who has an account.
+// Method: POST
+// Endpoint: /v1/tokens/password-reset
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if model.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{"message": "if an activated account exists for this email address, " +
+		"you will receive an email containing password reset instructions"}
+
+	user, err := app.dataAccessLayers.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, dal.ErrRecordNotFound):
+			app.writeTokenAccepted(w, r, env)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Only activated users can reset their password; anyone else gets the same response as
+	// an unknown email address.
+	if !user.Activated {
+		app.writeTokenAccepted(w, r, env)
+		return
+	}
+
+	// The reset token is only valid for 45 minutes.
+	token, err := app.dataAccessLayers.Tokens.New(r.Context(), user.ID,
+		45*time.Minute, model.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := map[string]any{
+		"passwordResetToken": token.Plaintext,
+	}
+
+	app.background(r.Context(), func(ctx context.Context) {
+		err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+		if err != nil {
+			app.logger.ErrorContext(ctx, err.Error())
+		}
+	})
+
+	app.writeTokenAccepted(w, r, env)
+}
+
+// The writeTokenAccepted() helper sends the 202 Accepted response for a password reset or
+// activation token request. It is used for every outcome which must look the same to the
+// client.
+func (app *application) writeTokenAccepted(w http.ResponseWriter, r *http.Request,
+	env envelope) {
+	err := app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createActivationTokenHandler() issues a new activation token for the user with the given
+// email address and emails it to them, for when the token from the welcome email has expired
+// or the email never arrived. Like the password reset endpoint, it sends the same response
+// whether or not the email address belongs to an account which still needs activating.
+// Method: POST
+// Endpoint: /v1/tokens/activation
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if model.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{"message": "if an account which hasn't been activated exists for this " +
+		"email address, you will receive an email containing activation instructions"}
+
+	user, err := app.dataAccessLayers.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, dal.ErrRecordNotFound):
+			app.writeTokenAccepted(w, r, env)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Activated {
+		app.writeTokenAccepted(w, r, env)
+		return
+	}
+
+	token, err := app.dataAccessLayers.Tokens.New(r.Context(), user.ID,
+		3*24*time.Hour, model.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := map[string]any{
+		"activationToken": token.Plaintext,
+	}
+
+	app.background(r.Context(), func(ctx context.Context) {
+		err := app.mailer.Send(user.Email, "token_activation.tmpl", data)
+		if err != nil {
+			app.logger.ErrorContext(ctx, err.Error())
+		}
+	})
+
+	app.writeTokenAccepted(w, r, env)
+}
+
+// createAPIKeyHandler() issues a long-lived API key for the authenticated user, which can be
+// sent in the X-API-Key header instead of an authentication token. Requests made with an API
+// key count against the quota of the user it belongs to. A user can only hold a limited number
+// of active API keys, and new keys can only be created with an authentication token, so that a
+// leaked API key can't be used to mint more.
+// Method: POST
+// Endpoint: /v1/tokens/api-key
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if app.authenticatedWithAPIKey(r) {
+		app.apiKeyNotAllowedResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	token, err := app.dataAccessLayers.Tokens.NewLimited(r.Context(), user.ID,
+		365*24*time.Hour, model.ScopeAPIKey, app.config.apiKeyLimit)
+	if err != nil {
+		switch {
+		case errors.Is(err, dal.ErrTooManyTokens):
+			app.apiKeyLimitResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_key": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCreatePasswordResetTokenHandler(t *testing.T) {
+	userColumns := []string{"id", "created_at", "name", "email", "password_hash", "activated",
+		"plan", "version"}
+
+	tests := []struct {
+		name       string
+		body       string
+		activated  bool
+		found      bool
+		wantStatus int
+		wantMail   bool
+	}{
+		{
+			name:       "Activated user",
+			body:       `{"email": "alice@example.com"}`,
+			found:      true,
+			activated:  true,
+			wantStatus: http.StatusAccepted,
+			wantMail:   true,
+		},
+		{
+			name:       "Inactive user",
+			body:       `{"email": "alice@example.com"}`,
+			found:      true,
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name:       "Unknown email",
+			body:       `{"email": "alice@example.com"}`,
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name:       "Invalid email",
+			body:       `{"email": "alice"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock, outbox := newTestApplication(t)
+			hash := &tokenHash{}
+
+			if tt.wantStatus == http.StatusAccepted {
+				query := mock.ExpectQuery("FROM users WHERE email = ").
+					WithArgs("alice@example.com")
+
+				if tt.found {
+					query.WillReturnRows(sqlmock.NewRows(userColumns).AddRow(7, time.Now(),
+						"Alice", "alice@example.com", []byte("hash"), tt.activated, "free", 1))
+				} else {
+					query.WillReturnError(sql.ErrNoRows)
+				}
+			}
+
+			if tt.wantMail {
+				mock.ExpectExec("INSERT INTO tokens").
+					WithArgs(hash, 7, sqlmock.AnyArg(), "password-reset").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			res := serve(app, app.createPasswordResetTokenHandler, http.MethodPost, tt.body)
+
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d; want %d", res.StatusCode, tt.wantStatus)
+			}
+
+			messages := outbox.Messages()
+
+			if !tt.wantMail {
+				if len(messages) != 0 {
+					t.Errorf("got %d messages; want none", len(messages))
+				}
+				return
+			}
+
+			if len(messages) != 1 {
+				t.Fatalf("got %d messages; want 1", len(messages))
+			}
+
+			msg := messages[0]
+			if msg.To != "alice@example.com" || msg.Subject != "Reset your Greenlight password" {
+				t.Errorf("got message %q to %q; want the reset message to alice@example.com",
+					msg.Subject, msg.To)
+			}
+
+			checkTokenMail(t, msg, hash.hash)
+		})
+	}
+}
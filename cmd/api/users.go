@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/rlr524/greenlight/internal/dal"
+	"github.com/rlr524/greenlight/internal/model"
+	"github.com/rlr524/greenlight/internal/validator"
+	"net/http"
+	"time"
+)
+
+// registerUserHandler() creates a new user account.
+// Method: POST
+// Endpoint: /v1/users
+func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Create an anonymous struct to hold the expected data from the request body.
+	var input struct {
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Copy the data from the request body into a new User struct. Notice also that we set the
+	// Activated field to false, which isn't strictly necessary because the Activated field will
+	// have the zero-value of false by default, but setting this explicitly helps make our
+	// intentions clear to anyone reading the code.
+	user := &model.User{
+		Name:      input.Name,
+		Email:     input.Email,
+		Activated: false,
+	}
+
+	v := validator.New()
+
+	// Validate the plaintext password before it is hashed, as bcrypt returns an error for
+	// passwords longer than 72 bytes, which would otherwise be reported as a server error.
+	if model.ValidatePasswordPlaintext(v, input.Password); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Use the Password.Set() method to generate and store the hashed and plaintext passwords.
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if model.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Insert the user data into the database. If we get an ErrDuplicateEmail error, use the
+	// v.AddError() method to manually add a message to the validator instance, and then call
+	// the failedValidationResponse() helper.
+	err = app.dataAccessLayers.Users.Insert(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, dal.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// After the user record has been created in the database, generate a new activation token
+	// for the user. The user has three days to activate their account.
+	token, err := app.dataAccessLayers.Tokens.New(r.Context(), user.ID,
+		3*24*time.Hour, model.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Send the welcome email, which contains the plaintext activation token. The template
+	// data is passed as a map, so that more items can be added to it easily later on.
+	data := map[string]any{
+		"activationToken": token.Plaintext,
+		"userID":          user.ID,
+	}
+
+	// Send the email in a background goroutine, so that the client doesn't have to wait for
+	// the SMTP server. By the time the email fails to send the response has already been
+	// written, so errors are only logged.
+	app.background(r.Context(), func(ctx context.Context) {
+		err := app.mailer.Send(user.Email, "user_welcome.tmpl", data)
+		if err != nil {
+			app.logger.ErrorContext(ctx, err.Error())
+		}
+	})
+
+	// The email is sent after the response, so send a 202 Accepted status code to indicate
+	// that the request has been accepted for processing but not completed.
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// activateUserHandler() activates a user account from a plaintext activation token.
+// Method: PUT
+// Endpoint: /v1/users/activated
+func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if model.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Retrieve the details of the user associated with the token. If no matching record is
+	// found, then we let the client know that the token they provided is not valid.
+	user, err := app.dataAccessLayers.Users.GetForToken(r.Context(), model.ScopeActivation,
+		input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, dal.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired activation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user.Activated = true
+
+	err = app.dataAccessLayers.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, dal.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Activated users can read the movie catalog by default. Write access is granted
+	// separately to the editorial team.
+	err = app.dataAccessLayers.Permissions.AddForUser(r.Context(), user.ID, model.PermissionMoviesRead)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// If everything went successfully, then we delete all activation tokens for the user so
+	// that none of them can be used again.
+	err = app.dataAccessLayers.Tokens.DeleteAllForUser(r.Context(), model.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateUserPasswordHandler() sets a new password for a user from a plaintext password reset
+// token, and then revokes all of the user's password reset tokens.
+// Method: PUT
+// Endpoint: /v1/users/password
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	model.ValidatePasswordPlaintext(v, input.Password)
+	model.ValidateTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.dataAccessLayers.Users.GetForToken(r.Context(), model.ScopePasswordReset,
+		input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, dal.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.dataAccessLayers.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, dal.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Delete all password reset tokens for the user, so that neither the token which was
+	// just used nor any other outstanding one can be used again.
+	err = app.dataAccessLayers.Tokens.DeleteAllForUser(r.Context(), model.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "your password was successfully reset"}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegisterUserHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		insertErr  error
+		wantStatus int
+		wantMail   bool
+	}{
+		{
+			name:       "Valid",
+			body:       `{"name": "Alice", "email": "alice@example.com", "password": "pa55word"}`,
+			wantStatus: http.StatusAccepted,
+			wantMail:   true,
+		},
+		{
+			name:       "Duplicate email",
+			body:       `{"name": "Alice", "email": "alice@example.com", "password": "pa55word"}`,
+			insertErr:  errors.New(`pq: duplicate key value violates unique constraint "users_email_key"`),
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "Password too long",
+			body: `{"name": "Alice", "email": "alice@example.com", "password": "` +
+				strings.Repeat("a", 73) + `"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "Invalid email",
+			body:       `{"name": "Alice", "email": "alice", "password": "pa55word"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock, outbox := newTestApplication(t)
+			hash := &tokenHash{}
+
+			switch {
+			case tt.insertErr != nil:
+				mock.ExpectQuery("INSERT INTO users").WillReturnError(tt.insertErr)
+			case tt.wantMail:
+				mock.ExpectQuery("INSERT INTO users").
+					WithArgs("Alice", "alice@example.com", sqlmock.AnyArg(), false).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "plan", "version"}).
+						AddRow(7, time.Now(), "free", 1))
+				mock.ExpectExec("INSERT INTO tokens").
+					WithArgs(hash, 7, sqlmock.AnyArg(), "activation").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			res := serve(app, app.registerUserHandler, http.MethodPost, tt.body)
+
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d; want %d", res.StatusCode, tt.wantStatus)
+			}
+
+			messages := outbox.Messages()
+
+			if !tt.wantMail {
+				if len(messages) != 0 {
+					t.Errorf("got %d messages; want none", len(messages))
+				}
+				return
+			}
+
+			if len(messages) != 1 {
+				t.Fatalf("got %d messages; want 1", len(messages))
+			}
+
+			msg := messages[0]
+			if msg.To != "alice@example.com" || msg.Subject != "Welcome to Greenlight!" {
+				t.Errorf("got message %q to %q; want the welcome message to alice@example.com",
+					msg.Subject, msg.To)
+			}
+
+			checkTokenMail(t, msg, hash.hash)
+		})
+	}
+}
@@ -3,7 +3,10 @@ module github.com/rlr524/greenlight
 go 1.22.2
 
 require (
-	github.com/joho/godotenv v1.5.1 // indirect
-	github.com/julienschmidt/httprouter v1.3.0 // indirect
-	github.com/lib/pq v1.10.9 // indirect
+	github.com/DATA-DOG/go-sqlmock v1.5.2
+	github.com/joho/godotenv v1.5.1
+	github.com/julienschmidt/httprouter v1.3.0
+	github.com/lib/pq v1.10.9
+	golang.org/x/crypto v0.33.0
+	golang.org/x/time v0.10.0
 )
@@ -10,24 +10,133 @@ that a
 package dal
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"github.com/rlr524/greenlight/internal/metrics"
+	"log/slog"
+	"time"
 )
 
 // ErrRecordNotFound defines a custom error and returns from any Get()
 // method when looking up a record that doesn't exist in the database.
+// ErrDuplicateEmail is returned when inserting or updating a user with an email
+// address that already belongs to another user. ErrQueryTimeout is returned when a
+// query doesn't complete within the query timeout. ErrTooManyTokens is returned when a
+// user already has the maximum number of active tokens of a scope.
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
+	ErrDuplicateEmail = errors.New("duplicate email")
+	ErrQueryTimeout   = errors.New("query timeout")
+	ErrTooManyTokens  = errors.New("too many tokens")
 )
 
+// ErrorsTotal counts the custom errors returned by the data access layers, labelled by error
+// type. It is exposed on the /metrics endpoint.
+var ErrorsTotal = metrics.NewCounterVec("greenlight_dal_errors_total",
+	"Total number of custom errors returned by the data access layers.", "error")
+
+// errorLabels maps each custom error to its label value in ErrorsTotal.
+var errorLabels = map[error]string{
+	ErrRecordNotFound: "record_not_found",
+	ErrEditConflict:   "edit_conflict",
+	ErrDuplicateEmail: "duplicate_email",
+	ErrQueryTimeout:   "query_timeout",
+	ErrTooManyTokens:  "too_many_tokens",
+}
+
+// track counts a custom error in ErrorsTotal and returns it unchanged, so that it can wrap the
+// error in a return statement.
+func track(err error) error {
+	if label, ok := errorLabels[err]; ok {
+		ErrorsTotal.Inc(label)
+	}
+
+	return err
+}
+
 // The DataAccessLayers struct wraps the MovieDAL and all additional data access layer types.
 type DataAccessLayers struct {
-	Movies MovieDAL
+	Movies         MovieDAL
+	MovieRevisions MovieRevisionDAL
+	Permissions    PermissionDAL
+	Tokens         TokenDAL
+	Users          UserDAL
 }
 
-func NewDALs(db *sql.DB) DataAccessLayers {
+// NewDALs returns the data access layers for the given connection pool. Every query is given
+// at most queryTimeout to complete, and query timeouts are logged with the logger.
+func NewDALs(db *sql.DB, queryTimeout time.Duration, logger *slog.Logger) DataAccessLayers {
+	c := conn{DB: db, Timeout: queryTimeout, Logger: logger}
+
 	return DataAccessLayers{
-		Movies: MovieDAL{DB: db},
+		Movies:         MovieDAL{c},
+		MovieRevisions: MovieRevisionDAL{c},
+		Permissions:    PermissionDAL{c},
+		Tokens:         TokenDAL{c},
+		Users:          UserDAL{c},
+	}
+}
+
+// The conn struct is embedded in every data access layer type and holds the connection pool
+// along with the settings which apply to every query.
+type conn struct {
+	DB      *sql.DB
+	Timeout time.Duration
+	Logger  *slog.Logger
+}
+
+// The withTimeout method derives the context for a query from the caller's context, adding the
+// query timeout. Because it is derived from the caller's context, the query is also canceled
+// as soon as the caller's context is, for example when the client of an HTTP request goes
+// away. The cancel function must always be called once the query is done.
+func (c conn) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, c.Timeout)
+}
+
+// The queryError method maps an error returned by a query run with the given context to
+// ErrQueryTimeout if the context's deadline has passed, and wraps it with context.Canceled if
+// the context was canceled. Any other error, including nil, is returned unchanged. The pq
+// driver reports a canceled query with an error of its own, which is why the context is
+// checked rather than the error.
+func (c conn) queryError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		c.Logger.WarnContext(ctx, "database query timed out", "timeout", c.Timeout,
+			"error", err.Error())
+		return track(ErrQueryTimeout)
+	case errors.Is(ctx.Err(), context.Canceled):
+		return fmt.Errorf("%w: %w", context.Canceled, err)
+	default:
+		return err
+	}
+}
+
+// The inTx method runs fn in a transaction, committing it if fn returns nil and rolling it back
+// otherwise. The query timeout applies to the transaction as a whole, and the context passed to
+// fn must be used for every query in it. Errors returned by fn are passed through unchanged, so
+// fn is expected to have mapped them with queryError() itself.
+func (c conn) inTx(ctx context.Context, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return c.queryError(ctx, err)
 	}
+	// Rolling back a committed transaction is a no-op, so this is always safe to defer.
+	defer tx.Rollback()
+
+	err = fn(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	return c.queryError(ctx, tx.Commit())
 }
@@ -10,19 +10,23 @@ type and implements all database CRUD operations for that type.
 package dal
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/lib/pq"
 	"github.com/rlr524/greenlight/internal/model"
+	"time"
 )
 
 type MovieDAL struct {
-	DB *sql.DB
+	conn
 }
 
-// The Insert method accepts a pointer to a movie
-// struct which should contain the data for the new record.
-func (m MovieDAL) Insert(movie *model.Movie) error {
+// The Insert method accepts a pointer to a movie struct which should contain the data for the
+// new record, along with the ID of the user creating it. The first revision of the movie is
+// recorded in the same transaction.
+func (m MovieDAL) Insert(ctx context.Context, movie *model.Movie, userID int64) error {
 	// Define the SQL query for inserting a new record into the
 	// movies table and returning the system-generated data.
 	query := `
@@ -35,15 +39,27 @@ func (m MovieDAL) Insert(movie *model.Movie) error {
 	// the SQL query helps to make it clear what values are being used where in the query.
 	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
-	// Use the QueryRow() method that is available from the Go database/sql library to execute
-	// the SQL query on the connection pool, passing in the args slice as a variadic parameter
-	// and scanning the system-generated id, created_at and version values into the movie struct.
-	return m.DB.QueryRow(query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	return m.inTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		// Use the QueryRowContext() method to execute the SQL query in the transaction, passing
+		// in the args slice as a variadic parameter and scanning the system-generated id,
+		// created_at and version values into the movie struct.
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt,
+			&movie.Version)
+		if err != nil {
+			return m.queryError(ctx, err)
+		}
+
+		// Every field is new in the first version.
+		changed := model.ChangedMovieFields(model.MovieSnapshot{}, movie.Snapshot())
+
+		err = insertMovieRevision(ctx, tx, movie.ID, changed, userID)
+		return m.queryError(ctx, err)
+	})
 }
 
-func (m MovieDAL) Get(id int64) (*model.Movie, error) {
+func (m MovieDAL) Get(ctx context.Context, id int64) (*model.Movie, error) {
 	if id < 1 {
-		return nil, ErrRecordNotFound
+		return nil, track(ErrRecordNotFound)
 	}
 
 	query := `
@@ -53,7 +69,10 @@ func (m MovieDAL) Get(id int64) (*model.Movie, error) {
 
 	var movie model.Movie
 
-	err := m.DB.QueryRow(query, id).Scan(
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
 		&movie.Title,
@@ -65,45 +84,243 @@ func (m MovieDAL) Get(id int64) (*model.Movie, error) {
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return nil, ErrRecordNotFound
+			return nil, track(ErrRecordNotFound)
 		default:
-			return nil, err
+			return nil, m.queryError(ctx, err)
 		}
 	}
 
 	return &movie, nil
 }
 
-func (m MovieDAL) GetAll() (*model.Movie, error) {
-	query := `
+// titleSearchQuery is the tsquery which movie titles are matched against for the search term
+// in the $2 parameter. The term is parsed and stemmed by plainto_tsquery() with the english
+// configuration, and then every lexeme in the text form of the result (such as
+// 'blade' & 'run') is turned into a prefix match by adding :* after its closing quote, so that
+// partial words match as well as stems (e.g. "blade run" and "runners" both match
+// "Blade Runner"). The lexemes are already stemmed, so the result is parsed again with the
+// simple configuration, which doesn't stem them a second time. The query only depends on the
+// search term, so the movies_title_search_idx index can still be used. A term made up of stop
+// words only gives an empty query, which matches nothing, as with plainto_tsquery().
+const titleSearchQuery = `to_tsquery('simple',
+			regexp_replace(plainto_tsquery('english', $2)::text, '''( |$)', ''':*\1', 'g'))`
+
+// The GetAll method returns a slice of movies which are not flagged as deleted, filtered by
+// title, full-text search query and genres and sorted and paginated according to the provided
+// filters, along with the pagination metadata for the result. An empty title, search query or
+// genres slice matches every movie.
+func (m MovieDAL) GetAll(ctx context.Context, title string, search string, genres []string,
+	filters model.Filters) ([]*model.Movie, model.Metadata, error) {
+	// The sort column and direction can't be passed as placeholder parameters, so they are
+	// interpolated into the query. This is safe because SortColumn() only returns values
+	// from the safelist. A secondary sort on the id column guarantees a consistent order
+	// between pages when several rows share the same value in the sort column. The count(*)
+	// OVER() window function returns the total number of filtered records on every row, which
+	// saves a separate round trip to calculate the pagination metadata.
+	//
+	// The search term is matched with PostgreSQL full-text search (see titleSearchQuery). The
+	// to_tsvector() expression must be identical to the one in the movies_title_search_idx
+	// index for the index to be used. The rank column is only meaningful when a search term
+	// is provided, and is used for sorting by relevance.
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version,
+			ts_rank(to_tsvector('english', title), %[1]s) AS rank
+		FROM movies
+		WHERE (LOWER(title) = LOWER($1) OR $1 = '')
+		AND (to_tsvector('english', title) @@ %[1]s OR $2 = '')
+		AND (genres @> $3 OR $3 = '{}')
+		AND deleted NOT IN (true)
+		ORDER BY %[2]s, id ASC
+		LIMIT $4 OFFSET $5`, titleSearchQuery, movieOrderBy(filters))
+
+	args := []any{title, search, pq.Array(genres), filters.Limit(), filters.Offset()}
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, model.Metadata{}, m.queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	// Initialize an empty slice rather than a nil one so that an empty result is encoded as
+	// an empty JSON array instead of null.
+	movies := []*model.Movie{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var movie model.Movie
+		var rank float64
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&rank,
+		)
+		if err != nil {
+			return nil, model.Metadata{}, m.queryError(ctx, err)
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	// rows.Err() picks up any error that was encountered during the iteration.
+	if err = rows.Err(); err != nil {
+		return nil, model.Metadata{}, m.queryError(ctx, err)
+	}
+
+	metadata := model.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// The GetAllByCursor method is the keyset pagination counterpart of GetAll. Rather than
+// skipping a number of rows with OFFSET, it starts the page directly after the (sort column, id)
+// position held in the cursor, which stays fast and stable however deep into the list the
+// client is and however many rows are inserted or deleted between pages. A nil cursor fetches
+// the first page. Sorting by relevance is not supported because ts_rank values are not
+// stable enough to be used as a key.
+func (m MovieDAL) GetAllByCursor(ctx context.Context, title string, search string,
+	genres []string, filters model.Filters,
+	cursor *model.Cursor) ([]*model.Movie, model.CursorMetadata, error) {
+	column := filters.SortColumn()
+
+	// The keyset condition mirrors the ORDER BY clause: rows come after the cursor if their sort
+	// value is past the cursor value in the sort direction, or if it is equal and their id is
+	// greater (as the id is always sorted in ascending order).
+	keyset := ""
+	if cursor != nil {
+		operator := ">"
+		if filters.SortDirection() == "DESC" {
+			operator = "<"
+		}
+
+		// The cursor value is cast to the type of the sort column, so that an integer which
+		// doesn't fit the column is compared rather than rejected by PostgreSQL.
+		value := "$5::bigint"
+		if column == "title" {
+			value = "$5::text"
+		}
+
+		keyset = fmt.Sprintf("AND (%[1]s %[2]s %[3]s OR (%[1]s = %[3]s AND id > $6))", column,
+			operator, value)
+	}
+
+	// One more row than the page size is fetched, so we know whether there is a next page
+	// without having to count the remaining rows.
+	query := fmt.Sprintf(`
 		SELECT id, created_at, title, year, runtime, genres, version
 		FROM movies
-		WHERE deleted NOT IN (true)`
+		WHERE (LOWER(title) = LOWER($1) OR $1 = '')
+		AND (to_tsvector('english', title) @@ %s OR $2 = '')
+		AND (genres @> $3 OR $3 = '{}')
+		AND deleted NOT IN (true)
+		%s
+		ORDER BY %s %s, id ASC
+		LIMIT $4`, titleSearchQuery, keyset, column, filters.SortDirection())
 
-	var movie model.Movie
+	args := []any{title, search, pq.Array(genres), filters.Limit() + 1}
+	if cursor != nil {
+		args = append(args, cursor.Value, cursor.ID)
+	}
 
-	err := m.DB.QueryRow(query).Scan(
-		&movie.ID,
-		&movie.CreatedAt,
-		&movie.Title,
-		&movie.Year,
-		&movie.Runtime,
-		pq.Array(&movie.Genres),
-		&movie.Version,
-	)
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return nil, ErrRecordNotFound
-		default:
-			return nil, err
+		return nil, model.CursorMetadata{}, m.queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	movies := []*model.Movie{}
+
+	for rows.Next() {
+		var movie model.Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, model.CursorMetadata{}, m.queryError(ctx, err)
 		}
+
+		movies = append(movies, &movie)
 	}
 
-	return &movie, nil
+	if err = rows.Err(); err != nil {
+		return nil, model.CursorMetadata{}, m.queryError(ctx, err)
+	}
+
+	if len(movies) == 0 {
+		return movies, model.CursorMetadata{}, nil
+	}
+
+	metadata := model.CursorMetadata{PageSize: filters.PageSize}
+
+	// If the extra row came back there is a next page, which starts after the last movie
+	// that is actually returned to the client.
+	if len(movies) > filters.Limit() {
+		movies = movies[:filters.Limit()]
+		last := movies[len(movies)-1]
+
+		next := model.Cursor{Sort: filters.Sort, Value: movieSortValue(last, column), ID: last.ID}
+		metadata.NextCursor = next.Encode()
+	}
+
+	return movies, metadata, nil
+}
+
+// movieSortValue returns the value of the given sort column for a movie.
+func movieSortValue(movie *model.Movie, column string) any {
+	switch column {
+	case "title":
+		return movie.Title
+	case "year":
+		return movie.Year
+	case "runtime":
+		return int32(movie.Runtime)
+	default:
+		return movie.ID
+	}
+}
+
+// movieOrderBy returns the ORDER BY expression for the given filters. Sorting by relevance
+// always puts the best matching movies first, so it is ordered by rank in descending order.
+func movieOrderBy(filters model.Filters) string {
+	if filters.SortColumn() == "relevance" {
+		return "rank DESC"
+	}
+
+	return fmt.Sprintf("%s %s", filters.SortColumn(), filters.SortDirection())
 }
 
-func (m MovieDAL) Update(movie *model.Movie) error {
+// The Update method saves the changes to a movie as a new version on behalf of the user with
+// the given ID, and records the new version as a revision along with the fields which changed.
+// The movie is passed as a pointer and its Version field is updated in place, so the caller's
+// copy matches the database once the transaction has been committed.
+func (m MovieDAL) Update(ctx context.Context, movie *model.Movie, userID int64) error {
+	// The current values are read first to work out which fields have changed. The row is
+	// locked until the transaction ends, so it can't change between the two queries.
+	previousQuery := `
+		SELECT title, year, runtime, genres
+		FROM movies
+		WHERE id = $1 AND version = $2 AND deleted NOT IN (true)
+		FOR UPDATE`
+
 	query := `
 		UPDATE movies
 		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
@@ -120,55 +337,212 @@ func (m MovieDAL) Update(movie *model.Movie) error {
 		movie.Version,
 	}
 
-	// If no matching row is found, we know the movie version has changed (or the record has been
-	// deleted) and we return the custom ErrEditConflict error.
-	err := m.DB.QueryRow(query, args...).Scan(&movie.Version)
+	return m.inTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var previous model.MovieSnapshot
+
+		// If no matching row is found, we know the movie version has changed (or the record has
+		// been deleted) and we return the custom ErrEditConflict error.
+		err := tx.QueryRowContext(ctx, previousQuery, movie.ID, movie.Version).Scan(
+			&previous.Title,
+			&previous.Year,
+			&previous.Runtime,
+			pq.Array(&previous.Genres),
+		)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return track(ErrEditConflict)
+			default:
+				return m.queryError(ctx, err)
+			}
+		}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+		if err != nil {
+			return m.queryError(ctx, err)
+		}
+
+		changed := model.ChangedMovieFields(previous, movie.Snapshot())
+
+		err = insertMovieRevision(ctx, tx, movie.ID, changed, userID)
+		return m.queryError(ctx, err)
+	})
+}
+
+// The Delete function implements the CRUD option for soft deletion of a single movie, moving
+// it to the trash. The time of deletion is recorded so that the movie can be purged once it
+// has been in the trash for longer than the retention period. Deleting a movie which is
+// already in the trash returns ErrRecordNotFound.
+func (m MovieDAL) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return track(ErrRecordNotFound)
+	}
+
+	query := `
+		UPDATE movies
+		SET deleted = true, deleted_at = NOW()
+		WHERE id = $1 AND deleted NOT IN (true)`
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return m.queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return m.queryError(ctx, err)
+	}
+
+	if rowsAffected == 0 {
+		return track(ErrRecordNotFound)
+	}
+
+	return nil
+}
+
+// The Restore method takes a movie back out of the trash, returning ErrRecordNotFound if there
+// is no movie with the given ID in the trash.
+func (m MovieDAL) Restore(ctx context.Context, id int64) (*model.Movie, error) {
+	if id < 1 {
+		return nil, track(ErrRecordNotFound)
+	}
+
+	query := `
+		UPDATE movies
+		SET deleted = false, deleted_at = NULL
+		WHERE id = $1 AND deleted = true
+		RETURNING id, created_at, title, year, runtime, genres, version`
+
+	var movie model.Movie
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+	)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
+			return nil, track(ErrRecordNotFound)
 		default:
-			return err
+			return nil, m.queryError(ctx, err)
 		}
 	}
-	return nil
 
-	// So what's happening here? Notice the Update method (it's a method because it has a
-	// receiver argument (m MovieDAL) for the MovieDAL type, so it is a method on MovieDAL) takes
-	// a pointer to the Movie struct in the movie.go model file. This is how the data is
-	// initially saved in memory when it's entered in the client. Because it's a pointer to the
-	// movie data, it mutates it in place in memory, it doesn't make a new copy of the movie
-	// data. Once that entry is complete, the query, with its arguments, is committed to the
-	// database by the return statement. The QueryRow function is from the sql package, and it
-	// executes a query on the database that returns a maximum of one row, which in our case
-	// will be the updated version of the movie we just updated.
+	return &movie, nil
 }
 
-// The Delete function implements the CRUD option for soft deletion of a single movie.
-// TODO: Update this to insert a timestamp instead of just a flag.
-func (m MovieDAL) Delete(id int64) error {
+// The HardDelete method permanently deletes a movie, whether or not it is in the trash, along
+// with its revisions.
+func (m MovieDAL) HardDelete(ctx context.Context, id int64) error {
 	if id < 1 {
-		return ErrRecordNotFound
+		return track(ErrRecordNotFound)
 	}
 
 	query := `
-		UPDATE movies
-		SET deleted = true
+		DELETE FROM movies
 		WHERE id = $1`
 
-	result, err := m.DB.Exec(query, id)
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
 	if err != nil {
-		return err
+		return m.queryError(ctx, err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return err
+		return m.queryError(ctx, err)
 	}
 
 	if rowsAffected == 0 {
-		return ErrRecordNotFound
+		return track(ErrRecordNotFound)
 	}
 
 	return nil
 }
+
+// The GetAllDeleted method returns a page of the movies in the trash, sorted according to the
+// provided filters, along with the pagination metadata for the result.
+func (m MovieDAL) GetAllDeleted(ctx context.Context,
+	filters model.Filters) ([]*model.Movie, model.Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, deleted,
+			deleted_at, version
+		FROM movies
+		WHERE deleted = true
+		ORDER BY %s %s, id ASC
+		LIMIT $1 OFFSET $2`, filters.SortColumn(), filters.SortDirection())
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.Limit(), filters.Offset())
+	if err != nil {
+		return nil, model.Metadata{}, m.queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	movies := []*model.Movie{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var movie model.Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Deleted,
+			&movie.DeletedAt,
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, model.Metadata{}, m.queryError(ctx, err)
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, model.Metadata{}, m.queryError(ctx, err)
+	}
+
+	metadata := model.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// The PurgeDeleted method permanently deletes the movies which have been in the trash for
+// longer than the retention period, and returns how many were deleted.
+func (m MovieDAL) PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error) {
+	query := `
+		DELETE FROM movies
+		WHERE deleted = true AND deleted_at < NOW() - make_interval(secs => $1)`
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, retention.Seconds())
+	if err != nil {
+		return 0, m.queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	return rowsAffected, m.queryError(ctx, err)
+}
@@ -0,0 +1,203 @@
+package dal
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rlr524/greenlight/internal/model"
+	"io"
+	"log/slog"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+)
+
+var movieSortSafelist = []string{"id", "title", "year", "runtime", "relevance", "-id", "-title",
+	"-year", "-runtime"}
+
+func newTestMovieDAL(t *testing.T) (MovieDAL, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	return NewDALs(db, time.Second, logger).Movies, mock
+}
+
+func TestMovieSearchIsPrefixMatched(t *testing.T) {
+	// Every lexeme of the search term is turned into a prefix match, in both pagination modes.
+	search := regexp.QuoteMeta(`@@ to_tsquery('simple', regexp_replace(` +
+		`plainto_tsquery('english', $2)::text, '''( |$)', ''':*\1', 'g'))`)
+
+	filters := model.Filters{Page: 1, PageSize: 20, Sort: "relevance",
+		SortSafelist: movieSortSafelist}
+
+	t.Run("GetAll", func(t *testing.T) {
+		m, mock := newTestMovieDAL(t)
+
+		mock.ExpectQuery("ts_rank\\(to_tsvector\\('english', title\\), to_tsquery\\('simple'.*"+
+			search).
+			WithArgs("", "blade run", sqlmock.AnyArg(), 20, 0).
+			WillReturnRows(sqlmock.NewRows([]string{"count", "id", "created_at", "title", "year",
+				"runtime", "genres", "version", "rank"}).
+				AddRow(1, 1, time.Now(), "Blade Runner", 1982, 117, "{sci-fi}", 1, 0.1))
+
+		movies, _, err := m.GetAll(context.Background(), "", "blade run", nil, filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(movies) != 1 {
+			t.Errorf("got %d movies; want 1", len(movies))
+		}
+	})
+
+	t.Run("GetAllByCursor", func(t *testing.T) {
+		m, mock := newTestMovieDAL(t)
+		filters := filters
+		filters.Sort = "title"
+
+		mock.ExpectQuery(search).
+			WithArgs("", "blade run", sqlmock.AnyArg(), 21).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "title", "year",
+				"runtime", "genres", "version"}))
+
+		_, _, err := m.GetAllByCursor(context.Background(), "", "blade run", nil, filters, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestGetAllByCursor(t *testing.T) {
+	columns := []string{"id", "created_at", "title", "year", "runtime", "genres", "version"}
+
+	// Each case fetches a page of two movies, so the query asks for three rows. The rows are
+	// returned in the order the query would sort them.
+	tests := []struct {
+		name       string
+		sort       string
+		cursor     *model.Cursor
+		wantSQL    string
+		wantArgs   []driver.Value
+		rows       [][]any
+		wantIDs    []int64
+		wantCursor *model.Cursor
+	}{
+		{
+			name:     "First page",
+			sort:     "id",
+			wantSQL:  `AND deleted NOT IN (true) ORDER BY id ASC, id ASC LIMIT $4`,
+			wantArgs: []driver.Value{int64(3)},
+			rows:     [][]any{{1, "Alien", 1979, 117}, {2, "Heat", 1995, 170}},
+			wantIDs:  []int64{1, 2},
+		},
+		{
+			name:   "Title ascending",
+			sort:   "title",
+			cursor: &model.Cursor{Sort: "title", Value: "Alien", ID: 1},
+			wantSQL: `AND (title > $5::text OR (title = $5::text AND id > $6)) ` +
+				`ORDER BY title ASC, id ASC LIMIT $4`,
+			wantArgs: []driver.Value{int64(3), "Alien", int64(1)},
+			rows: [][]any{{2, "Blade Runner", 1982, 117}, {3, "Heat", 1995, 170},
+				{4, "Ran", 1985, 162}},
+			wantIDs:    []int64{2, 3},
+			wantCursor: &model.Cursor{Sort: "title", Value: "Heat", ID: 3},
+		},
+		{
+			name:   "Title descending",
+			sort:   "-title",
+			cursor: &model.Cursor{Sort: "-title", Value: "Ran", ID: 4},
+			wantSQL: `AND (title < $5::text OR (title = $5::text AND id > $6)) ` +
+				`ORDER BY title DESC, id ASC LIMIT $4`,
+			wantArgs: []driver.Value{int64(3), "Ran", int64(4)},
+			rows:     [][]any{{3, "Heat", 1995, 170}},
+			wantIDs:  []int64{3},
+		},
+		{
+			name:   "Year ascending",
+			sort:   "year",
+			cursor: &model.Cursor{Sort: "year", Value: int64(1979), ID: 1},
+			wantSQL: `AND (year > $5::bigint OR (year = $5::bigint AND id > $6)) ` +
+				`ORDER BY year ASC, id ASC LIMIT $4`,
+			wantArgs: []driver.Value{int64(3), int64(1979), int64(1)},
+			rows: [][]any{{2, "Blade Runner", 1982, 117}, {4, "Ran", 1985, 162},
+				{3, "Heat", 1995, 170}},
+			wantIDs:    []int64{2, 4},
+			wantCursor: &model.Cursor{Sort: "year", Value: int64(1985), ID: 4},
+		},
+		{
+			name:   "Runtime descending",
+			sort:   "-runtime",
+			cursor: &model.Cursor{Sort: "-runtime", Value: int64(170), ID: 3},
+			wantSQL: `AND (runtime < $5::bigint OR (runtime = $5::bigint AND id > $6)) ` +
+				`ORDER BY runtime DESC, id ASC LIMIT $4`,
+			wantArgs: []driver.Value{int64(3), int64(170), int64(3)},
+			rows: [][]any{{4, "Ran", 1985, 162}, {1, "Alien", 1979, 117},
+				{2, "Blade Runner", 1982, 117}},
+			wantIDs:    []int64{4, 1},
+			wantCursor: &model.Cursor{Sort: "-runtime", Value: int64(117), ID: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, mock := newTestMovieDAL(t)
+
+			rows := sqlmock.NewRows(columns)
+			for _, row := range tt.rows {
+				rows.AddRow(row[0], time.Now(), row[1], row[2], row[3], "{drama}", 1)
+			}
+
+			args := append([]driver.Value{"", "", sqlmock.AnyArg()}, tt.wantArgs...)
+			mock.ExpectQuery(regexp.QuoteMeta(tt.wantSQL) + "$").
+				WithArgs(args...).
+				WillReturnRows(rows)
+
+			filters := model.Filters{PageSize: 2, Sort: tt.sort, SortSafelist: movieSortSafelist}
+
+			movies, metadata, err := m.GetAllByCursor(context.Background(), "", "", nil, filters,
+				tt.cursor)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var ids []int64
+			for _, movie := range movies {
+				ids = append(ids, movie.ID)
+			}
+
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("got movies %v; want %v", ids, tt.wantIDs)
+			}
+
+			if tt.wantCursor == nil {
+				if metadata.NextCursor != "" {
+					t.Errorf("got next cursor %q; want none", metadata.NextCursor)
+				}
+				return
+			}
+
+			next, err := model.DecodeCursor(metadata.NextCursor)
+			if err != nil {
+				t.Fatalf("decoding next cursor %q: %v", metadata.NextCursor, err)
+			}
+
+			if *next != *tt.wantCursor {
+				t.Errorf("got next cursor %+v; want %+v", *next, *tt.wantCursor)
+			}
+		})
+	}
+}
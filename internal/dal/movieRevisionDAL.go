@@ -0,0 +1,150 @@
+/*
+internal/dal/movieRevisionDAL.go
+- The movieRevisionDAL.go file is the data access layer for movie
+revisions. Revisions are written by the MovieDAL, in the same transaction as
+the change to the movie, and are read back here.
+*/
+
+package dal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/rlr524/greenlight/internal/model"
+)
+
+type MovieRevisionDAL struct {
+	conn
+}
+
+// movieRevisionColumns selects a movie revision, unpacking the snapshot so that it can be
+// scanned by scanMovieRevision().
+const movieRevisionColumns = `
+	movie_id, version, snapshot->>'title', (snapshot->>'year')::integer,
+	(snapshot->>'runtime')::integer,
+	ARRAY(SELECT jsonb_array_elements_text(snapshot->'genres')),
+	changed_fields, user_id, created_at`
+
+// The GetAllForMovie method returns the revisions of a movie, newest first, paginated
+// according to the provided filters, along with the pagination metadata for the result.
+func (m MovieRevisionDAL) GetAllForMovie(ctx context.Context, movieID int64,
+	filters model.Filters) ([]*model.MovieRevision, model.Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM movie_revisions
+		WHERE movie_id = $1
+		ORDER BY version %s
+		LIMIT $2 OFFSET $3`, movieRevisionColumns, filters.SortDirection())
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.Limit(), filters.Offset())
+	if err != nil {
+		return nil, model.Metadata{}, m.queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	revisions := []*model.MovieRevision{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var revision model.MovieRevision
+
+		err := scanMovieRevision(rows, &revision, &totalRecords)
+		if err != nil {
+			return nil, model.Metadata{}, m.queryError(ctx, err)
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, model.Metadata{}, m.queryError(ctx, err)
+	}
+
+	metadata := model.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
+
+// The Get method returns a single version of a movie.
+func (m MovieRevisionDAL) Get(ctx context.Context, movieID int64,
+	version int32) (*model.MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, track(ErrRecordNotFound)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM movie_revisions
+		WHERE movie_id = $1 AND version = $2`, movieRevisionColumns)
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var revision model.MovieRevision
+
+	err := scanMovieRevision(m.DB.QueryRowContext(ctx, query, movieID, version), &revision)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, track(ErrRecordNotFound)
+		default:
+			return nil, m.queryError(ctx, err)
+		}
+	}
+
+	return &revision, nil
+}
+
+// scanMovieRevision scans a row selected with movieRevisionColumns into revision. Any extra
+// destinations are scanned from the columns before them.
+func scanMovieRevision(row interface{ Scan(...any) error }, revision *model.MovieRevision,
+	extra ...any) error {
+	var userID sql.NullInt64
+
+	dest := append(extra,
+		&revision.MovieID,
+		&revision.Version,
+		&revision.Snapshot.Title,
+		&revision.Snapshot.Year,
+		&revision.Snapshot.Runtime,
+		pq.Array(&revision.Snapshot.Genres),
+		pq.Array(&revision.ChangedFields),
+		&userID,
+		&revision.CreatedAt,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return err
+	}
+
+	if userID.Valid {
+		revision.UserID = &userID.Int64
+	}
+
+	return nil
+}
+
+// insertMovieRevision records the current state of a movie as a revision, in the transaction
+// which changed it. The snapshot is built from the movies row itself, so it always matches
+// what was written.
+func insertMovieRevision(ctx context.Context, tx *sql.Tx, movieID int64,
+	changedFields []string, userID int64) error {
+	query := `
+		INSERT INTO movie_revisions (movie_id, version, snapshot, changed_fields, user_id)
+		SELECT id, version,
+			jsonb_build_object('title', title, 'year', year, 'runtime', runtime,
+				'genres', genres),
+			$2, $3
+		FROM movies
+		WHERE id = $1`
+
+	_, err := tx.ExecContext(ctx, query, movieID, pq.Array(changedFields), userID)
+	return err
+}
@@ -0,0 +1,71 @@
+/*
+internal/dal/permissionDAL.go
+- The permissionDAL.go file is the data access layer for user
+permissions and implements the database operations for them.
+*/
+
+package dal
+
+import (
+	"context"
+	"github.com/lib/pq"
+	"github.com/rlr524/greenlight/internal/model"
+)
+
+type PermissionDAL struct {
+	conn
+}
+
+// The GetAllForUser method returns all permission codes for a specific user in a
+// Permissions slice.
+func (m PermissionDAL) GetAllForUser(ctx context.Context, userID int64) (model.Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		INNER JOIN users ON users_permissions.user_id = users.id
+		WHERE users.id = $1`
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, m.queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	var permissions model.Permissions
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, m.queryError(ctx, err)
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, m.queryError(ctx, err)
+	}
+
+	return permissions, nil
+}
+
+// The AddForUser method adds the provided permission codes for a specific user. Codes the
+// user already has are ignored, so it is safe to call more than once.
+func (m PermissionDAL) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	query := `
+		INSERT INTO users_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return m.queryError(ctx, err)
+}
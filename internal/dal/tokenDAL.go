@@ -0,0 +1,110 @@
+/*
+internal/dal/tokenDAL.go
+- The tokenDAL.go file is the data access layer for the Token
+type and implements the database operations for that type.
+*/
+
+package dal
+
+import (
+	"context"
+	"database/sql"
+	"github.com/rlr524/greenlight/internal/model"
+	"time"
+)
+
+type TokenDAL struct {
+	conn
+}
+
+// The New method is a shortcut which creates a new Token struct and then inserts the data in
+// the tokens table.
+func (m TokenDAL) New(ctx context.Context, userID int64, ttl time.Duration,
+	scope string) (*model.Token, error) {
+	token, err := model.GenerateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+// The NewLimited method is like New, but returns ErrTooManyTokens instead of creating the token
+// if the user already has limit or more unexpired tokens of the scope. The user's row is
+// locked while the tokens are counted, so concurrent requests can't both slip under the limit.
+func (m TokenDAL) NewLimited(ctx context.Context, userID int64, ttl time.Duration, scope string,
+	limit int) (*model.Token, error) {
+	token, err := model.GenerateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	lockQuery := `
+		SELECT id FROM users WHERE id = $1 FOR UPDATE`
+
+	countQuery := `
+		SELECT count(*)
+		FROM tokens
+		WHERE user_id = $1 AND scope = $2 AND expiry > $3`
+
+	insertQuery := `
+		INSERT INTO tokens (hash, user_id, expiry, scope)
+		VALUES ($1, $2, $3, $4)`
+
+	err = m.inTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, lockQuery, userID)
+		if err != nil {
+			return m.queryError(ctx, err)
+		}
+
+		var count int
+
+		err = tx.QueryRowContext(ctx, countQuery, userID, scope, time.Now()).Scan(&count)
+		if err != nil {
+			return m.queryError(ctx, err)
+		}
+
+		if count >= limit {
+			return track(ErrTooManyTokens)
+		}
+
+		_, err = tx.ExecContext(ctx, insertQuery, token.Hash, token.UserID, token.Expiry,
+			token.Scope)
+		return m.queryError(ctx, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// The Insert method adds the data for a specific token to the tokens table. Only the hash of
+// the token is stored, never the plaintext.
+func (m TokenDAL) Insert(ctx context.Context, token *model.Token) error {
+	query := `
+		INSERT INTO tokens (hash, user_id, expiry, scope)
+		VALUES ($1, $2, $3, $4)`
+
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return m.queryError(ctx, err)
+}
+
+// The DeleteAllForUser method deletes all tokens for a specific user and scope.
+func (m TokenDAL) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	query := `
+		DELETE FROM tokens
+		WHERE scope = $1 AND user_id = $2`
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, scope, userID)
+	return m.queryError(ctx, err)
+}
@@ -0,0 +1,175 @@
+/*
+internal/dal/userDAL.go
+- The userDAL.go file is the data access layer for the User
+type and implements all database CRUD operations for that type.
+*/
+
+package dal
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"github.com/rlr524/greenlight/internal/model"
+	"time"
+)
+
+type UserDAL struct {
+	conn
+}
+
+// The Insert method inserts a new record for the user into the database. The id, created_at
+// and version fields are all automatically generated by the database and scanned back into
+// the user struct.
+func (m UserDAL) Insert(ctx context.Context, user *model.User) error {
+	query := `
+		INSERT INTO users (name, email, password_hash, activated)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, plan, version`
+
+	args := []any{user.Name, user.Email, user.Password.Hash, user.Activated}
+
+	// If the table already contains a record with this email address, then when we try to
+	// perform the insert there will be a violation of the UNIQUE "users_email_key" constraint
+	// that we set up in the migration. We check for this error specifically, and return the
+	// custom ErrDuplicateEmail error instead.
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt,
+		&user.Plan, &user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return track(ErrDuplicateEmail)
+		default:
+			return m.queryError(ctx, err)
+		}
+	}
+
+	return nil
+}
+
+// The GetByEmail method retrieves the user details from the database based on the user's
+// email address. Because there is a UNIQUE constraint on the email column, this query will
+// only ever return one record (or none at all, in which case ErrRecordNotFound is returned).
+func (m UserDAL) GetByEmail(ctx context.Context, email string) (*model.User, error) {
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, plan, version
+		FROM users
+		WHERE email = $1`
+
+	var user model.User
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.Hash,
+		&user.Activated,
+		&user.Plan,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, track(ErrRecordNotFound)
+		default:
+			return nil, m.queryError(ctx, err)
+		}
+	}
+
+	return &user, nil
+}
+
+// The Update method updates the details for a specific user. The version number is checked
+// in the same way as MovieDAL.Update() to prevent race conditions, and a violation of the
+// email UNIQUE constraint is mapped to ErrDuplicateEmail as in Insert().
+func (m UserDAL) Update(ctx context.Context, user *model.User) error {
+	query := `
+		UPDATE users
+		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
+		WHERE id = $5 AND version = $6
+		RETURNING version`
+
+	args := []any{
+		user.Name,
+		user.Email,
+		user.Password.Hash,
+		user.Activated,
+		user.ID,
+		user.Version,
+	}
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return track(ErrDuplicateEmail)
+		case errors.Is(err, sql.ErrNoRows):
+			return track(ErrEditConflict)
+		default:
+			return m.queryError(ctx, err)
+		}
+	}
+
+	return nil
+}
+
+// The GetForToken method retrieves the user associated with a plaintext token of the given
+// scope, provided the token hasn't expired. If there is no matching token, ErrRecordNotFound
+// is returned.
+func (m UserDAL) GetForToken(ctx context.Context, tokenScope,
+	tokenPlaintext string) (*model.User, error) {
+	// Calculate the SHA-256 hash of the plaintext token provided by the client. Remember that
+	// this returns a byte *array* with length 32, not a slice.
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash,
+			users.activated, users.plan, users.version
+		FROM users
+		INNER JOIN tokens
+		ON users.id = tokens.user_id
+		WHERE tokens.hash = $1
+		AND tokens.scope = $2
+		AND tokens.expiry > $3`
+
+	// Use the [:] operator to get a slice containing the token hash, rather than passing in
+	// the array (which is not supported by the pq driver).
+	args := []any{tokenHash[:], tokenScope, time.Now()}
+
+	var user model.User
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.Hash,
+		&user.Activated,
+		&user.Plan,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, track(ErrRecordNotFound)
+		default:
+			return nil, m.queryError(ctx, err)
+		}
+	}
+
+	return &user, nil
+}
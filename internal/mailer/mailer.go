@@ -0,0 +1,97 @@
+/*
+internal/mailer/mailer.go
+- The mailer package renders emails from the templates embedded in the
+binary and hands them to a Transport for delivery, which is either an
+SMTP server or a local outbox used during development and testing.
+*/
+
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	ht "html/template"
+	tt "text/template"
+	"time"
+)
+
+// templateFS holds the email templates. The comment directive below must be placed
+// immediately above the variable declaration, and tells the Go compiler to embed the
+// contents of the ./templates directory into the binary.
+//
+//go:embed "templates"
+var templateFS embed.FS
+
+// Message is a fully rendered email, ready to be delivered by a Transport.
+type Message struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Subject   string    `json:"subject"`
+	PlainBody string    `json:"plain_body"`
+	HTMLBody  string    `json:"html_body"`
+	Date      time.Time `json:"date"`
+}
+
+// Transport is implemented by anything which can deliver a rendered Message.
+type Transport interface {
+	Send(msg Message) error
+}
+
+// Mailer renders messages from the embedded templates and sends them using its Transport
+// with the configured sender information (e.g. "Greenlight <no-reply@greenlight.net>").
+type Mailer struct {
+	transport Transport
+	sender    string
+}
+
+func New(transport Transport, sender string) Mailer {
+	return Mailer{
+		transport: transport,
+		sender:    sender,
+	}
+}
+
+// Send renders the "subject", "plainBody" and "htmlBody" templates from the given template
+// file with the dynamic data and sends the result to the recipient. The plain text parts are
+// rendered with text/template and the HTML part with html/template, so that dynamic data in
+// the HTML part is escaped correctly.
+func (m Mailer) Send(recipient, templateFile string, data any) error {
+	textTmpl, err := tt.New("").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return err
+	}
+
+	subject := new(bytes.Buffer)
+	err = textTmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = textTmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return err
+	}
+
+	htmlTmpl, err := ht.New("").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return err
+	}
+
+	msg := Message{
+		From:      m.sender,
+		To:        recipient,
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+		Date:      time.Now(),
+	}
+
+	return m.transport.Send(msg)
+}
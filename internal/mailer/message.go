@@ -0,0 +1,66 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"time"
+)
+
+// Bytes encodes the message in RFC 5322 format as a multipart/alternative email with a plain
+// text and an HTML part. Email clients display the last part they are able to render, so the
+// HTML part comes last.
+func (msg Message) Bytes() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	mw := multipart.NewWriter(buf)
+
+	headers := []struct{ key, value string }{
+		{"From", msg.From},
+		{"To", msg.To},
+		{"Subject", mime.QEncoding.Encode("utf-8", msg.Subject)},
+		{"Date", msg.Date.Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", mw.Boundary())},
+	}
+
+	for _, h := range headers {
+		fmt.Fprintf(buf, "%s: %s\r\n", h.key, h.value)
+	}
+	buf.WriteString("\r\n")
+
+	parts := []struct{ contentType, body string }{
+		{"text/plain; charset=UTF-8", msg.PlainBody},
+		{"text/html; charset=UTF-8", msg.HTMLBody},
+	}
+
+	for _, p := range parts {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		qw := quotedprintable.NewWriter(pw)
+		_, err = qw.Write([]byte(p.body))
+		if err != nil {
+			return nil, err
+		}
+
+		err = qw.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := mw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,73 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Outbox is a Transport which captures messages locally instead of delivering them, for use
+// in development and tests. Every message is kept in memory, and if a directory is set, it is
+// also written to that directory as an .eml file which can be opened in any email client.
+type Outbox struct {
+	dir      string
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewOutbox returns an Outbox which writes messages to the given directory, or only keeps them
+// in memory if dir is empty.
+func NewOutbox(dir string) *Outbox {
+	return &Outbox{dir: dir}
+}
+
+func (o *Outbox) Send(msg Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.dir != "" {
+		body, err := msg.Bytes()
+		if err != nil {
+			return err
+		}
+
+		err = os.MkdirAll(o.dir, 0o755)
+		if err != nil {
+			return err
+		}
+
+		// The sequence number keeps file names unique even for messages which are sent
+		// within the same nanosecond.
+		name := fmt.Sprintf("%d-%04d.eml", msg.Date.UnixNano(), len(o.messages)+1)
+
+		err = os.WriteFile(filepath.Join(o.dir, name), body, 0o644)
+		if err != nil {
+			return err
+		}
+	}
+
+	o.messages = append(o.messages, msg)
+
+	return nil
+}
+
+// Messages returns a copy of all messages sent through the outbox, oldest first.
+func (o *Outbox) Messages() []Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	messages := make([]Message, len(o.messages))
+	copy(messages, o.messages)
+
+	return messages
+}
+
+// Reset discards all captured messages. Files already written to the directory are left
+// in place.
+func (o *Outbox) Reset() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.messages = nil
+}
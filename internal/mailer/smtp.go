@@ -0,0 +1,109 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+// SMTPTransport delivers messages through an SMTP server. STARTTLS is used whenever the server
+// supports it, and the connection is authenticated with PLAIN auth if a username is set.
+type SMTPTransport struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Timeout  time.Duration
+}
+
+// NewSMTPTransport returns an SMTPTransport which gives up on a message after 5 seconds.
+func NewSMTPTransport(host string, port int, username, password string) *SMTPTransport {
+	return &SMTPTransport{
+		Host:     host,
+		Port:     port,
+		Username: username,
+		Password: password,
+		Timeout:  5 * time.Second,
+	}
+}
+
+func (t *SMTPTransport) Send(msg Message) error {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %w", err)
+	}
+
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address: %w", err)
+	}
+
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	// net/smtp doesn't support timeouts itself, so we dial the connection ourselves and set a
+	// deadline which covers the whole SMTP conversation.
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(t.Host, fmt.Sprint(t.Port)), t.Timeout)
+	if err != nil {
+		return err
+	}
+
+	err = conn.SetDeadline(time.Now().Add(t.Timeout))
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, t.Host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: t.Host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if t.Username != "" {
+		err = c.Auth(smtp.PlainAuth("", t.Username, t.Password, t.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Mail(from.Address)
+	if err != nil {
+		return err
+	}
+
+	err = c.Rcpt(to.Address)
+	if err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(body)
+	if err != nil {
+		return err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
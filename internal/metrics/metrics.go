@@ -0,0 +1,325 @@
+/*
+internal/metrics/metrics.go
+- The metrics package implements the small subset of Prometheus metric types
+the application needs (counters, gauges and histograms, with labels), and
+renders them in the Prometheus text exposition format.
+*/
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets, in seconds, which suit the response times
+// of a typical API.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Collector is implemented by every metric type. Write renders the metric, including its HELP
+// and TYPE lines, in the Prometheus text format.
+type Collector interface {
+	Write(w io.Writer) error
+}
+
+// Registry holds the collectors which are exposed together by its Handler.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds collectors to the registry. Collectors are rendered in the order they
+// were registered.
+func (r *Registry) Register(collectors ...Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, collectors...)
+}
+
+// WriteTo renders every registered collector in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := make([]Collector, len(r.collectors))
+	copy(collectors, r.collectors)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	for _, c := range collectors {
+		err := c.Write(bw)
+		if err != nil {
+			return cw.n, err
+		}
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler returns an http.Handler which serves the registry in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = r.WriteTo(w)
+	})
+}
+
+// CounterVec is a counter partitioned by one or more labels.
+type CounterVec struct {
+	name       string
+	help       string
+	labelNames []string
+	mu         sync.Mutex
+	values     map[string]float64
+}
+
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		values:     make(map[string]float64),
+	}
+}
+
+// Inc adds one to the counter with the given label values, which must be given in the same
+// order as the label names.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter with the given label values.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := labelKey(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[key] += v
+}
+
+func (c *CounterVec) Write(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+
+	for _, key := range sortedKeys(c.values) {
+		labels := formatLabels(c.labelNames, splitLabelKey(key))
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labels, formatValue(c.values[key]))
+	}
+
+	return nil
+}
+
+// HistogramVec is a histogram partitioned by one or more labels.
+type HistogramVec struct {
+	name       string
+	help       string
+	buckets    []float64
+	labelNames []string
+	mu         sync.Mutex
+	values     map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec creates a histogram with the given upper bucket bounds, which must be sorted
+// in increasing order. The +Inf bucket is added automatically.
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return &HistogramVec{
+		name:       name,
+		help:       help,
+		buckets:    buckets,
+		labelNames: labelNames,
+		values:     make(map[string]*histogram),
+	}
+}
+
+// Observe records a single observation in the histogram with the given label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := labelKey(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hist, found := h.values[key]
+	if !found {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+
+	// Only the first bucket the value fits in is incremented here; the counts are made
+	// cumulative when the histogram is written.
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.buckets) {
+		hist.counts[i]++
+	}
+
+	hist.sum += v
+	hist.count++
+}
+
+func (h *HistogramVec) Write(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		hist := h.values[key]
+		labelValues := splitLabelKey(key)
+		bucketNames := append(append([]string{}, h.labelNames...), "le")
+
+		var cumulative uint64
+		for i, upperBound := range h.buckets {
+			cumulative += hist.counts[i]
+			labels := formatLabels(bucketNames, append(append([]string{}, labelValues...),
+				formatValue(upperBound)))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, cumulative)
+		}
+
+		labels := formatLabels(bucketNames, append(append([]string{}, labelValues...), "+Inf"))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, hist.count)
+
+		labels = formatLabels(h.labelNames, labelValues)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatValue(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, hist.count)
+	}
+
+	return nil
+}
+
+// GaugeFunc is a gauge whose value is calculated by calling a function every time the
+// registry is scraped.
+type GaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	return &GaugeFunc{name: name, help: help, fn: fn}
+}
+
+func (g *GaugeFunc) Write(w io.Writer) error {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatValue(g.fn()))
+
+	return nil
+}
+
+// CounterFunc is a counter whose value is read by calling a function every time the registry is
+// scraped. It is useful for exposing counters which are maintained elsewhere, such as the
+// wait count in sql.DBStats. The function must return a value which never decreases.
+type CounterFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+func NewCounterFunc(name, help string, fn func() float64) *CounterFunc {
+	return &CounterFunc{name: name, help: help, fn: fn}
+}
+
+func (c *CounterFunc) Write(w io.Writer) error {
+	writeHeader(w, c.name, c.help, "counter")
+	fmt.Fprintf(w, "%s %s\n", c.name, formatValue(c.fn()))
+
+	return nil
+}
+
+func writeHeader(w io.Writer, name, help, metricType string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
+
+// labelValueEscaper escapes label values as required by the text format.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelValueEscaper.Replace(value))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// Label values are joined into a single map key with a separator which can't appear in
+// valid UTF-8 text.
+const labelSeparator = "\xff"
+
+func labelKey(values []string) string {
+	return strings.Join(values, labelSeparator)
+}
+
+func splitLabelKey(key string) []string {
+	return strings.Split(key, labelSeparator)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// countingWriter counts the bytes written through it, for Registry.WriteTo().
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCounterVecWrite(t *testing.T) {
+	tests := []struct {
+		name string
+		inc  [][]string
+		want string
+	}{
+		{
+			name: "No values",
+			want: "# HELP test_total Test counter.\n" +
+				"# TYPE test_total counter\n",
+		},
+		{
+			name: "Sorted by label values",
+			inc:  [][]string{{"b"}, {"a"}, {"b"}},
+			want: "# HELP test_total Test counter.\n" +
+				"# TYPE test_total counter\n" +
+				"test_total{error=\"a\"} 1\n" +
+				"test_total{error=\"b\"} 2\n",
+		},
+		{
+			name: "Escaped label values",
+			inc:  [][]string{{"quote\" backslash\\ newline\n"}},
+			want: "# HELP test_total Test counter.\n" +
+				"# TYPE test_total counter\n" +
+				"test_total{error=\"quote\\\" backslash\\\\ newline\\n\"} 1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCounterVec("test_total", "Test counter.", "error")
+			for _, labelValues := range tt.inc {
+				c.Inc(labelValues...)
+			}
+
+			var sb strings.Builder
+			err := c.Write(&sb)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := sb.String(); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistogramVecWrite(t *testing.T) {
+	h := NewHistogramVec("test_seconds", "Test histogram.", []float64{0.1, 1}, "route")
+
+	// 0.1 falls in the 0.1 bucket, as the upper bounds are inclusive, and 5 only counts
+	// towards the +Inf bucket.
+	for _, v := range []float64{0.05, 0.1, 0.5, 5} {
+		h.Observe(v, "/v1/movies")
+	}
+
+	want := "# HELP test_seconds Test histogram.\n" +
+		"# TYPE test_seconds histogram\n" +
+		"test_seconds_bucket{route=\"/v1/movies\",le=\"0.1\"} 2\n" +
+		"test_seconds_bucket{route=\"/v1/movies\",le=\"1\"} 3\n" +
+		"test_seconds_bucket{route=\"/v1/movies\",le=\"+Inf\"} 4\n" +
+		"test_seconds_sum{route=\"/v1/movies\"} 5.65\n" +
+		"test_seconds_count{route=\"/v1/movies\"} 4\n"
+
+	var sb strings.Builder
+	err := h.Write(&sb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sb.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := NewRegistry()
+	r.Register(
+		NewGaugeFunc("b_gauge", "Help with a \\ backslash\nand a newline.",
+			func() float64 { return 2.5 }),
+		NewCounterFunc("a_total", "A counter.", func() float64 { return 7 }),
+	)
+
+	// Collectors are written in the order they were registered, not sorted by name.
+	want := "# HELP b_gauge Help with a \\\\ backslash\\nand a newline.\n" +
+		"# TYPE b_gauge gauge\n" +
+		"b_gauge 2.5\n" +
+		"# HELP a_total A counter.\n" +
+		"# TYPE a_total counter\n" +
+		"a_total 7\n"
+
+	var sb strings.Builder
+	n, err := r.WriteTo(&sb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sb.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if n != int64(len(want)) {
+		t.Errorf("got %d bytes written; want %d", n, len(want))
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value float64
+		want  string
+	}{
+		{"Integer", 3, "3"},
+		{"Fraction", 0.25, "0.25"},
+		{"Large", 1e21, "1e+21"},
+		{"Positive infinity", math.Inf(1), "+Inf"},
+		{"Negative infinity", math.Inf(-1), "-Inf"},
+		{"NaN", math.NaN(), "NaN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatValue(tt.value); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
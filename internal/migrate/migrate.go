@@ -0,0 +1,342 @@
+/*
+internal/migrate/migrate.go
+- The migrate package applies the versioned SQL migrations to the database.
+The current version is tracked in the schema_migrations table, using the same
+layout as the golang-migrate tool so that databases it has already migrated
+carry on from where they are.
+*/
+
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// lockID is the key of the PostgreSQL advisory lock which is held while migrations are read
+// or applied, so that several instances starting at once don't race to apply the same
+// migration.
+const lockID = 7_263_418_092
+
+var (
+	// ErrDirty is returned when a previous migration failed part way through and the database
+	// has to be fixed by hand, and the version then set with Force().
+	ErrDirty = errors.New("database is dirty, fix it manually and force the version")
+	// ErrUnknownVersion is returned when asked to migrate to a version which doesn't exist.
+	ErrUnknownVersion = errors.New("unknown migration version")
+)
+
+var fileRX = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a single version, holding the SQL to apply and revert it.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Migrator struct {
+	DB         *sql.DB
+	Logger     *slog.Logger
+	migrations []Migration
+}
+
+// New reads the migration files from the root of fsys. Every version must have an up file,
+// down files are optional.
+func New(db *sql.DB, fsys fs.FS, logger *slog.Logger) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+
+	for _, entry := range entries {
+		matches := fileRX.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("invalid migration version in %s", entry.Name())
+		}
+
+		b, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = m
+		}
+
+		if matches[3] == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+
+	migrator := &Migrator{DB: db, Logger: logger}
+
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up file", m.Version)
+		}
+		migrator.migrations = append(migrator.migrations, *m)
+	}
+
+	sort.Slice(migrator.migrations, func(i, j int) bool {
+		return migrator.migrations[i].Version < migrator.migrations[j].Version
+	})
+
+	return migrator, nil
+}
+
+// Latest returns the highest migration version, or 0 if there are no migrations.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the current version of the database and whether it is dirty. A database
+// which has never been migrated is at version 0.
+func (m *Migrator) Version(ctx context.Context) (int64, bool, error) {
+	var version int64
+	var dirty bool
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		var err error
+		version, dirty, err = currentVersion(ctx, conn)
+		return err
+	})
+
+	return version, dirty, err
+}
+
+// Up applies every migration which hasn't been applied yet.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.Goto(ctx, m.Latest())
+}
+
+// Down reverts every applied migration.
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.Goto(ctx, 0)
+}
+
+// Goto applies or reverts migrations, one transaction per migration, until the database is at
+// the target version. The target must be 0 or the version of an existing migration.
+func (m *Migrator) Goto(ctx context.Context, target int64) error {
+	if target != 0 && m.index(target) < 0 {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, target)
+	}
+
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		current, dirty, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("%w (version %d)", ErrDirty, current)
+		}
+
+		for _, migration := range m.migrations {
+			if migration.Version <= current || migration.Version > target {
+				continue
+			}
+
+			err := m.apply(ctx, conn, migration.Up, migration.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", migration.Version, migration.Name,
+					err)
+			}
+			m.Logger.Info("applied migration", "version", migration.Version,
+				"name", migration.Name, "direction", "up")
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if migration.Version > current || migration.Version <= target {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down file", migration.Version,
+					migration.Name)
+			}
+
+			// Reverting a migration takes the database back to the version before it.
+			previous := int64(0)
+			if i > 0 {
+				previous = m.migrations[i-1].Version
+			}
+
+			err := m.apply(ctx, conn, migration.Down, previous)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", migration.Version,
+					migration.Name, err)
+			}
+			m.Logger.Info("reverted migration", "version", migration.Version,
+				"name", migration.Name, "direction", "down")
+		}
+
+		return nil
+	})
+}
+
+// Force sets the version of the database and clears the dirty flag, without running any
+// migrations. It is used to recover after a failed migration has been fixed by hand.
+func (m *Migrator) Force(ctx context.Context, version int64) error {
+	if version != 0 && m.index(version) < 0 {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		err = setVersion(ctx, tx, version)
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	})
+}
+
+// apply runs the SQL of a single migration and records the new version in the same
+// transaction, so a failed migration leaves the database at the version it was before rather
+// than dirty.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, query string,
+	version int64) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	err = setVersion(ctx, tx, version)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// withLock runs fn on a single connection while holding the migration advisory lock. The lock
+// belongs to the database session, so the same connection has to be used to release it.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockID)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockID)
+
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint NOT NULL PRIMARY KEY,
+			dirty boolean NOT NULL
+		)`
+
+	_, err = conn.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// index returns the position of the given version in the migrations slice, or -1 if there is
+// no migration with that version.
+func (m *Migrator) index(version int64) int {
+	for i, migration := range m.migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// currentVersion reads the version from the schema_migrations table, which holds at most
+// one row. The dirty flag is only ever set by golang-migrate, when one of its migrations has
+// failed.
+func currentVersion(ctx context.Context, conn *sql.Conn) (int64, bool, error) {
+	var version int64
+	var dirty bool
+
+	err := conn.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").
+		Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+
+	return version, dirty, err
+}
+
+// setVersion replaces the row in the schema_migrations table. Version 0 is stored as no row.
+func setVersion(ctx context.Context, tx *sql.Tx, version int64) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+
+	if version == 0 {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)", version)
+	return err
+}
+
+// Status returns the current version of the database and whether it is dirty, without taking
+// the advisory lock, so that it doesn't block while migrations are being applied elsewhere. It
+// is meant for health checks; a database without a schema_migrations table is at version 0.
+func (m *Migrator) Status(ctx context.Context) (int64, bool, error) {
+	var exists bool
+
+	err := m.DB.QueryRowContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").
+		Scan(&exists)
+	if err != nil || !exists {
+		return 0, false, err
+	}
+
+	var version int64
+	var dirty bool
+
+	err = m.DB.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").
+		Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+
+	return version, dirty, err
+}
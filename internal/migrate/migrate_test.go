@@ -0,0 +1,89 @@
+package migrate
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"testing/fstest"
+)
+
+func TestNew(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name         string
+		files        fstest.MapFS
+		wantVersions []int64
+		wantErr      bool
+	}{
+		{
+			name: "Sorted by version",
+			files: fstest.MapFS{
+				"000010_add_index.up.sql":       {Data: []byte("CREATE INDEX")},
+				"000002_create_movies.up.sql":   {Data: []byte("CREATE TABLE")},
+				"000002_create_movies.down.sql": {Data: []byte("DROP TABLE")},
+			},
+			wantVersions: []int64{2, 10},
+		},
+		{
+			name: "Other files ignored",
+			files: fstest.MapFS{
+				"000001_create_movies.up.sql": {Data: []byte("CREATE TABLE")},
+				"migrations.go":               {Data: []byte("package migrations")},
+				"README.md":                   {Data: []byte("")},
+			},
+			wantVersions: []int64{1},
+		},
+		{
+			name:         "No migrations",
+			files:        fstest.MapFS{},
+			wantVersions: nil,
+		},
+		{
+			name: "Missing up file",
+			files: fstest.MapFS{
+				"000001_create_movies.down.sql": {Data: []byte("DROP TABLE")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Version zero",
+			files: fstest.MapFS{
+				"000000_create_movies.up.sql": {Data: []byte("CREATE TABLE")},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := New(nil, tt.files, logger)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("got no error; want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(m.migrations) != len(tt.wantVersions) {
+				t.Fatalf("got %d migrations; want %d", len(m.migrations), len(tt.wantVersions))
+			}
+			for i, want := range tt.wantVersions {
+				if got := m.migrations[i].Version; got != want {
+					t.Errorf("got version %d at %d; want %d", got, i, want)
+				}
+			}
+
+			wantLatest := int64(0)
+			if len(tt.wantVersions) > 0 {
+				wantLatest = tt.wantVersions[len(tt.wantVersions)-1]
+			}
+			if got := m.Latest(); got != wantLatest {
+				t.Errorf("got latest %d; want %d", got, wantLatest)
+			}
+		})
+	}
+}
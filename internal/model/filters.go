@@ -0,0 +1,173 @@
+package model
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/rlr524/greenlight/internal/validator"
+	"math"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidCursor is returned by DecodeCursor() when a client-supplied cursor can't be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Filters holds the pagination and sorting parameters which are common to all list endpoints.
+// SortSafelist contains the only values the Sort field is allowed to take, which is what
+// makes it safe to interpolate the sort column into an SQL query.
+type Filters struct {
+	Page         int
+	PageSize     int
+	Sort         string
+	SortSafelist []string
+}
+
+func ValidateFilters(v *validator.Validator, f Filters) {
+	// Check that the page and page_size parameters contain sensible values.
+	v.Check(f.Page > 0, "page", "must be greater than zero")
+	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
+	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
+	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+
+	// Check that the sort parameter matches a value in the safelist.
+	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+}
+
+// SortColumn checks that the client-provided Sort field matches one of the entries in the
+// safelist and if it does, extracts the column name from the Sort field by stripping the
+// leading hyphen character (if one exists). If there is no match we panic; this should never
+// happen because the filters are validated before they reach the DAL, and it acts as a sanity
+// check to help stop an SQL injection attack.
+func (f Filters) SortColumn() string {
+	for _, safeValue := range f.SortSafelist {
+		if f.Sort == safeValue {
+			return strings.TrimPrefix(f.Sort, "-")
+		}
+	}
+
+	panic("unsafe sort parameter: " + f.Sort)
+}
+
+// SortDirection returns the sort direction ("ASC" or "DESC") depending on the prefix
+// character of the Sort field.
+func (f Filters) SortDirection() string {
+	if strings.HasPrefix(f.Sort, "-") {
+		return "DESC"
+	}
+
+	return "ASC"
+}
+
+// Limit returns the number of records to fetch for a single page.
+func (f Filters) Limit() int {
+	return f.PageSize
+}
+
+// Offset returns the number of records to skip to reach the current page. The page and
+// page_size values are capped by ValidateFilters(), so there is no risk of overflow here.
+func (f Filters) Offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+// Metadata holds the pagination information returned alongside a page of records. The
+// omitempty directives mean that an empty Metadata struct is encoded as an empty JSON object.
+type Metadata struct {
+	CurrentPage  int `json:"current_page,omitempty"`
+	PageSize     int `json:"page_size,omitempty"`
+	FirstPage    int `json:"first_page,omitempty"`
+	LastPage     int `json:"last_page,omitempty"`
+	TotalRecords int `json:"total_records,omitempty"`
+}
+
+// CalculateMetadata calculates the pagination metadata values given the total number of
+// records, the current page and the page size. If there are no records, an empty Metadata
+// struct is returned.
+func CalculateMetadata(totalRecords, page, pageSize int) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		TotalRecords: totalRecords,
+	}
+}
+
+// Cursor marks the position of the last record on a page for keyset pagination. It holds the
+// sort the page was fetched with, along with the value of the sort column and the id of the
+// last record, which together uniquely identify where the next page starts. Clients only ever
+// see it in its encoded, opaque form.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value any    `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+// Encode returns the cursor as an opaque, URL-safe string.
+func (c Cursor) Encode() string {
+	// Marshalling a struct of strings, numbers and an int64 can't fail, so the error
+	// is safe to ignore.
+	js, _ := json.Marshal(c)
+
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// DecodeCursor parses a cursor previously returned by Encode(). Cursors only ever hold a string
+// or an integer sort value; numeric values are decoded as json.Number rather than float64 so
+// that they round trip without losing precision, and are returned as an int64. Any other value
+// means the cursor has been tampered with.
+func DecodeCursor(s string) (*Cursor, error) {
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	var c Cursor
+	err = dec.Decode(&c)
+	if err != nil || c.ID < 1 {
+		return nil, ErrInvalidCursor
+	}
+
+	switch value := c.Value.(type) {
+	case string:
+	case json.Number:
+		i, err := value.Int64()
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		c.Value = i
+	default:
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+// ValidateCursor checks that a decoded cursor was issued for the sort in the filters, and that
+// its value has the type of the sort column. The textColumns are the sort columns which hold
+// text; every other sort column must hold an integer.
+func ValidateCursor(v *validator.Validator, c *Cursor, f Filters, textColumns ...string) {
+	v.Check(c.Sort == f.Sort, "cursor", "does not match the sort parameter")
+
+	if c.Sort != f.Sort {
+		return
+	}
+
+	_, isText := c.Value.(string)
+	v.Check(isText == slices.Contains(textColumns, f.SortColumn()), "cursor", "invalid cursor")
+}
+
+// CursorMetadata holds the pagination information returned alongside a page of records fetched
+// with keyset pagination. NextCursor is empty on the last page.
+type CursorMetadata struct {
+	PageSize   int    `json:"page_size,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
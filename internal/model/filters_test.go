@@ -0,0 +1,123 @@
+package model
+
+import (
+	"encoding/base64"
+	"github.com/rlr524/greenlight/internal/validator"
+	"testing"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	encode := func(js string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(js))
+	}
+
+	tests := []struct {
+		name      string
+		cursor    string
+		wantValue any
+		wantErr   error
+	}{
+		{
+			name:      "Round trip string",
+			cursor:    Cursor{Sort: "title", Value: "Moana", ID: 3}.Encode(),
+			wantValue: "Moana",
+		},
+		{
+			name:      "Round trip integer",
+			cursor:    Cursor{Sort: "-year", Value: int32(2016), ID: 3}.Encode(),
+			wantValue: int64(2016),
+		},
+		{
+			name:      "Large integer keeps precision",
+			cursor:    encode(`{"s":"id","v":9007199254740993,"id":1}`),
+			wantValue: int64(9007199254740993),
+		},
+		{
+			name:    "Not base64",
+			cursor:  "not a cursor!",
+			wantErr: ErrInvalidCursor,
+		},
+		{
+			name:    "Not JSON",
+			cursor:  encode(`cursor`),
+			wantErr: ErrInvalidCursor,
+		},
+		{
+			name:    "Missing value",
+			cursor:  encode(`{"s":"id","id":1}`),
+			wantErr: ErrInvalidCursor,
+		},
+		{
+			name:    "Object value",
+			cursor:  encode(`{"s":"title","v":{"a":1},"id":1}`),
+			wantErr: ErrInvalidCursor,
+		},
+		{
+			name:    "Array value",
+			cursor:  encode(`{"s":"title","v":["a"],"id":1}`),
+			wantErr: ErrInvalidCursor,
+		},
+		{
+			name:    "Boolean value",
+			cursor:  encode(`{"s":"title","v":true,"id":1}`),
+			wantErr: ErrInvalidCursor,
+		},
+		{
+			name:    "Fractional value",
+			cursor:  encode(`{"s":"year","v":1.5,"id":1}`),
+			wantErr: ErrInvalidCursor,
+		},
+		{
+			name:    "Invalid ID",
+			cursor:  encode(`{"s":"id","v":1,"id":0}`),
+			wantErr: ErrInvalidCursor,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := DecodeCursor(tt.cursor)
+			if err != tt.wantErr {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+
+			if err == nil && c.Value != tt.wantValue {
+				t.Errorf("got value %#v; want %#v", c.Value, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestValidateCursor(t *testing.T) {
+	filters := func(sort string) Filters {
+		return Filters{Page: 1, PageSize: 20, Sort: sort,
+			SortSafelist: []string{"id", "title", "year", "-id", "-title", "-year"}}
+	}
+
+	tests := []struct {
+		name    string
+		cursor  Cursor
+		filters Filters
+		wantErr string
+	}{
+		{"Text column", Cursor{Sort: "title", Value: "Moana", ID: 1}, filters("title"), ""},
+		{"Integer column", Cursor{Sort: "-year", Value: int64(2016), ID: 1}, filters("-year"), ""},
+		{"Text value for integer column", Cursor{Sort: "year", Value: "2016", ID: 1},
+			filters("year"), "invalid cursor"},
+		{"Integer value for text column", Cursor{Sort: "title", Value: int64(1), ID: 1},
+			filters("title"), "invalid cursor"},
+		{"Different sort", Cursor{Sort: "id", Value: int64(1), ID: 1}, filters("-id"),
+			"does not match the sort parameter"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateCursor(v, &tt.cursor, tt.filters, "title")
+
+			if got := v.Errors["cursor"]; got != tt.wantErr {
+				t.Errorf("got error %q; want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
@@ -6,15 +6,16 @@ import (
 )
 
 type Movie struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Title     string    `json:"title"`
-	Year      int32     `json:"year"`
-	Runtime   Runtime   `json:"runtime,omitempty"`
-	Genres    []string  `json:"genres"`
-	Deleted   bool      `default:"false" json:"deleted"`
-	Version   int32     `json:"version"`
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Title     string     `json:"title"`
+	Year      int32      `json:"year"`
+	Runtime   Runtime    `json:"runtime,omitempty"`
+	Genres    []string   `json:"genres"`
+	Deleted   bool       `default:"false" json:"deleted"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Version   int32      `json:"version"`
 }
 
 func ValidateMovie(v *validator.Validator, movie *Movie) {
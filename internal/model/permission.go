@@ -0,0 +1,21 @@
+package model
+
+import (
+	"slices"
+)
+
+// Constants for the permission codes stored in the permissions table.
+const (
+	PermissionMoviesRead  = "movies:read"
+	PermissionMoviesWrite = "movies:write"
+	PermissionAdmin       = "admin"
+)
+
+// Permissions holds the permission codes (like "movies:read" and "movies:write") for a
+// single user.
+type Permissions []string
+
+// Include checks whether the Permissions slice contains a specific permission code.
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
+}
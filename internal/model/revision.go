@@ -0,0 +1,71 @@
+package model
+
+import (
+	"slices"
+	"time"
+)
+
+// MovieSnapshot holds the editable fields of a movie as they were at one version.
+type MovieSnapshot struct {
+	Title   string   `json:"title"`
+	Year    int32    `json:"year"`
+	Runtime Runtime  `json:"runtime"`
+	Genres  []string `json:"genres"`
+}
+
+// MovieRevision is a single recorded version of a movie. ChangedFields lists the fields which
+// differ from the previous version (every field for the first version), and UserID is the
+// user who made the change, which is nil if it isn't known or the user has been deleted.
+type MovieRevision struct {
+	MovieID       int64         `json:"movie_id"`
+	Version       int32         `json:"version"`
+	Snapshot      MovieSnapshot `json:"snapshot"`
+	ChangedFields []string      `json:"changed_fields"`
+	UserID        *int64        `json:"user_id"`
+	CreatedAt     time.Time     `json:"created_at"`
+}
+
+// FieldChange describes how a single field differs between two snapshots.
+type FieldChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// Snapshot returns the editable fields of the movie.
+func (m *Movie) Snapshot() MovieSnapshot {
+	return MovieSnapshot{Title: m.Title, Year: m.Year, Runtime: m.Runtime, Genres: m.Genres}
+}
+
+// DiffMovieSnapshots returns the fields which differ between two snapshots, in a fixed order.
+// An empty (non-nil) slice is returned if the snapshots are identical.
+func DiffMovieSnapshots(from, to MovieSnapshot) []FieldChange {
+	changes := []FieldChange{}
+
+	if from.Title != to.Title {
+		changes = append(changes, FieldChange{Field: "title", From: from.Title, To: to.Title})
+	}
+	if from.Year != to.Year {
+		changes = append(changes, FieldChange{Field: "year", From: from.Year, To: to.Year})
+	}
+	if from.Runtime != to.Runtime {
+		changes = append(changes, FieldChange{Field: "runtime", From: from.Runtime,
+			To: to.Runtime})
+	}
+	if !slices.Equal(from.Genres, to.Genres) {
+		changes = append(changes, FieldChange{Field: "genres", From: from.Genres,
+			To: to.Genres})
+	}
+
+	return changes
+}
+
+// ChangedMovieFields returns the names of the fields which differ between two snapshots.
+func ChangedMovieFields(from, to MovieSnapshot) []string {
+	fields := []string{}
+	for _, change := range DiffMovieSnapshots(from, to) {
+		fields = append(fields, change.Field)
+	}
+
+	return fields
+}
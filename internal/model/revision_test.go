@@ -0,0 +1,70 @@
+package model
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestChangedMovieFields(t *testing.T) {
+	moana := MovieSnapshot{Title: "Moana", Year: 2016, Runtime: 107,
+		Genres: []string{"animation", "adventure"}}
+
+	tests := []struct {
+		name string
+		from MovieSnapshot
+		to   MovieSnapshot
+		want []string
+	}{
+		{
+			name: "Identical",
+			from: moana,
+			to:   moana,
+			want: []string{},
+		},
+		{
+			name: "First version",
+			from: MovieSnapshot{},
+			to:   moana,
+			want: []string{"title", "year", "runtime", "genres"},
+		},
+		{
+			name: "Title and runtime",
+			from: moana,
+			to: MovieSnapshot{Title: "Moana 2", Year: 2016, Runtime: 100,
+				Genres: []string{"animation", "adventure"}},
+			want: []string{"title", "runtime"},
+		},
+		{
+			name: "Genres reordered",
+			from: moana,
+			to: MovieSnapshot{Title: "Moana", Year: 2016, Runtime: 107,
+				Genres: []string{"adventure", "animation"}},
+			want: []string{"genres"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ChangedMovieFields(tt.from, tt.to)
+			if got == nil || !slices.Equal(got, tt.want) {
+				t.Errorf("got %#v; want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffMovieSnapshots(t *testing.T) {
+	from := MovieSnapshot{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}}
+	to := MovieSnapshot{Title: "Moana", Year: 2017, Runtime: 107, Genres: []string{"animation"}}
+
+	changes := DiffMovieSnapshots(from, to)
+
+	if len(changes) != 1 {
+		t.Fatalf("got %d changes; want 1", len(changes))
+	}
+
+	change := changes[0]
+	if change.Field != "year" || change.From != int32(2016) || change.To != int32(2017) {
+		t.Errorf("got %+v; want year changed from 2016 to 2017", change)
+	}
+}
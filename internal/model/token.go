@@ -0,0 +1,67 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"github.com/rlr524/greenlight/internal/validator"
+	"time"
+)
+
+// Constants for the token scopes. A token can only be used for the purpose its scope was
+// issued for.
+const (
+	ScopeActivation     = "activation"
+	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeAPIKey         = "api-key"
+)
+
+// Token holds the data for an individual token. Only the Plaintext and Expiry fields are
+// ever included in a JSON response; the hash is what is stored in the database, so that a
+// leaked tokens table can't be used to impersonate anyone.
+type Token struct {
+	Plaintext string    `json:"token"`
+	Hash      []byte    `json:"-"`
+	UserID    int64     `json:"-"`
+	Expiry    time.Time `json:"expiry"`
+	Scope     string    `json:"-"`
+}
+
+// GenerateToken creates a new token for the given user, time to live and scope. The plaintext
+// token is made from 16 cryptographically random bytes, which gives 128 bits of entropy.
+func GenerateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token := &Token{
+		UserID: userID,
+		Expiry: time.Now().Add(ttl),
+		Scope:  scope,
+	}
+
+	randomBytes := make([]byte, 16)
+
+	// Fill the byte slice with random bytes from the operating system's CSPRNG. This returns
+	// an error if the CSPRNG fails to function correctly.
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Encode the byte slice to a base-32-encoded string without padding, which results in a
+	// 26 character string such as "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU".
+	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	// Generate a SHA-256 hash of the plaintext token string. This will be the value that is
+	// stored in the hash field of the database table. sha256.Sum256() returns an array, so it
+	// is converted to a slice with the [:] operator before storing it.
+	hash := sha256.Sum256([]byte(token.Plaintext))
+	token.Hash = hash[:]
+
+	return token, nil
+}
+
+// ValidateTokenPlaintext checks that the plaintext token provided by the client is exactly
+// 26 bytes long.
+func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+	v.Check(tokenPlaintext != "", "token", "must be provided")
+	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+	"time"
+)
+
+func TestGenerateToken(t *testing.T) {
+	token, err := GenerateToken(42, time.Hour, ScopeActivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(token.Plaintext) != 26 {
+		t.Errorf("got plaintext of %d bytes; want 26", len(token.Plaintext))
+	}
+
+	// Only the hash is stored, so it must be the SHA-256 hash of the plaintext for lookups
+	// by plaintext to work.
+	hash := sha256.Sum256([]byte(token.Plaintext))
+	if !bytes.Equal(token.Hash, hash[:]) {
+		t.Error("hash is not the SHA-256 hash of the plaintext")
+	}
+
+	if token.UserID != 42 || token.Scope != ScopeActivation {
+		t.Errorf("got user %d and scope %q; want 42 and %q", token.UserID, token.Scope,
+			ScopeActivation)
+	}
+
+	if until := time.Until(token.Expiry); until <= 59*time.Minute || until > time.Hour {
+		t.Errorf("got expiry in %s; want in 1h", until)
+	}
+
+	other, err := GenerateToken(42, time.Hour, ScopeActivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if other.Plaintext == token.Plaintext {
+		t.Error("two tokens have the same plaintext")
+	}
+}
@@ -0,0 +1,111 @@
+package model
+
+import (
+	"errors"
+	"github.com/rlr524/greenlight/internal/validator"
+	"golang.org/x/crypto/bcrypt"
+	"time"
+)
+
+// Constants for the plans a user can be on. The plan decides the size of the user's
+// request quota.
+const (
+	PlanFree    = "free"
+	PlanPartner = "partner"
+)
+
+// AnonymousUser represents an unauthenticated user, which is stored in the request context
+// when a request doesn't carry an Authorization header.
+var AnonymousUser = &User{}
+
+// User represents an individual user account. The Password field uses the json:"-" struct tag
+// so that the password hash is never included in a JSON response.
+type User struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Password  Password  `json:"-"`
+	Activated bool      `json:"activated"`
+	Plan      string    `json:"plan"`
+	Version   int       `json:"version"`
+}
+
+// IsAnonymous checks if a User instance is the AnonymousUser.
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
+}
+
+// Password holds the plaintext and hashed versions of a user's password. Plaintext is a pointer
+// to a string so that we can distinguish between a password not being present in the struct
+// at all, versus a password which is the empty string "".
+type Password struct {
+	Plaintext *string
+	Hash      []byte
+}
+
+// Set calculates the bcrypt hash of a plaintext password and stores both the hash and the
+// plaintext versions in the struct. A cost of 12 strikes a reasonable balance between
+// security and the time it takes to register or log in.
+func (p *Password) Set(plaintextPassword string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	if err != nil {
+		return err
+	}
+
+	p.Plaintext = &plaintextPassword
+	p.Hash = hash
+
+	return nil
+}
+
+// Matches checks whether the provided plaintext password matches the hashed password stored
+// in the struct, returning true if it does and false otherwise.
+func (p *Password) Matches(plaintextPassword string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(p.Hash, []byte(plaintextPassword))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func ValidateEmail(v *validator.Validator, email string) {
+	v.Check(email != "", "email", "must be provided")
+	v.Check(validator.Matches(email, validator.EmailRegEx), "email",
+		"must be a valid email address")
+}
+
+// ValidatePasswordPlaintext checks the length of a plaintext password. The upper limit of 72
+// bytes is there because bcrypt truncates (or, in newer versions, rejects) anything longer.
+func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+	v.Check(password != "", "password", "must be provided")
+	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
+	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+}
+
+func ValidateUser(v *validator.Validator, user *User) {
+	v.Check(user.Name != "", "name", "must be provided")
+	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	ValidateEmail(v, user.Email)
+
+	// If the plaintext password is not nil, call the standalone ValidatePasswordPlaintext()
+	// helper.
+	if user.Password.Plaintext != nil {
+		ValidatePasswordPlaintext(v, *user.Password.Plaintext)
+	}
+
+	// If the password hash is ever nil, this will be due to a logic error in our codebase
+	// (probably because we forgot to set a password for the user). It's a useful sanity
+	// check to include here, but it's not a problem with the data provided by the client. So
+	// rather than adding an error to the validation map we panic instead.
+	if user.Password.Hash == nil {
+		panic("missing password hash for user")
+	}
+}
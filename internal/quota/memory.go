@@ -0,0 +1,58 @@
+package quota
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps quota counts in memory. Counts are local to a single API instance, so it
+// is only suitable when one instance is running.
+type MemoryStore struct {
+	mu        sync.Mutex
+	counters  map[string]*counter
+	lastSweep time.Time
+}
+
+type counter struct {
+	windowStart time.Time
+	window      time.Duration
+	count       int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		counters:  make(map[string]*counter),
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit int,
+	window time.Duration) (Result, error) {
+	now := time.Now()
+	start := windowStart(now, window)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, found := s.counters[key]
+	if !found || !c.windowStart.Equal(start) {
+		c = &counter{windowStart: start, window: window}
+		s.counters[key] = c
+	}
+
+	c.count++
+
+	// Counters for windows which have ended are removed at most once per window, so that
+	// the map doesn't grow without bounds.
+	if now.Sub(s.lastSweep) > window {
+		for k, c := range s.counters {
+			if now.After(c.windowStart.Add(c.window)) {
+				delete(s.counters, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	return newResult(c.count, limit, start, window), nil
+}
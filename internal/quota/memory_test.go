@@ -0,0 +1,99 @@
+package quota
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreTake(t *testing.T) {
+	tests := []struct {
+		name          string
+		limit         int
+		requests      int
+		wantRemaining int
+		wantAllowed   bool
+	}{
+		{"First request", 3, 1, 2, true},
+		{"Last allowed request", 3, 3, 0, true},
+		{"Over the limit", 3, 4, 0, false},
+		{"Zero limit", 0, 1, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMemoryStore()
+
+			var (
+				result Result
+				err    error
+			)
+			for range tt.requests {
+				result, err = s.Take(context.Background(), "user:1", tt.limit, time.Hour)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if result.Limit != tt.limit {
+				t.Errorf("got limit %d; want %d", result.Limit, tt.limit)
+			}
+			if result.Remaining != tt.wantRemaining {
+				t.Errorf("got remaining %d; want %d", result.Remaining, tt.wantRemaining)
+			}
+			if result.Allowed != tt.wantAllowed {
+				t.Errorf("got allowed %t; want %t", result.Allowed, tt.wantAllowed)
+			}
+			if want := time.Now().Truncate(time.Hour).Add(time.Hour); !result.Reset.Equal(want) {
+				t.Errorf("got reset %s; want %s", result.Reset, want)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreKeysAreSeparate(t *testing.T) {
+	s := NewMemoryStore()
+
+	for range 2 {
+		_, err := s.Take(context.Background(), "ip:192.0.2.1", 2, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := s.Take(context.Background(), "ip:192.0.2.2", 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Remaining != 1 {
+		t.Errorf("got remaining %d; want 1", result.Remaining)
+	}
+}
+
+func TestMemoryStoreNewWindow(t *testing.T) {
+	s := NewMemoryStore()
+	window := 50 * time.Millisecond
+
+	// Wait for the start of a window, so that both requests fit in it.
+	time.Sleep(time.Until(time.Now().Truncate(window).Add(window)))
+
+	for range 2 {
+		_, err := s.Take(context.Background(), "user:1", 2, window)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(time.Until(time.Now().Truncate(window).Add(window)))
+
+	result, err := s.Take(context.Background(), "user:1", 2, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Allowed || result.Remaining != 1 {
+		t.Errorf("got allowed %t and remaining %d; want the count to restart in a new window",
+			result.Allowed, result.Remaining)
+	}
+}
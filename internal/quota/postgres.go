@@ -0,0 +1,73 @@
+package quota
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// PostgresStore keeps quota counts in the quotas table, so that every API instance connected
+// to the same database shares the same counts. Every query is given at most Timeout to
+// complete, so that a slow database can't hold up the requests being counted.
+type PostgresStore struct {
+	DB      *sql.DB
+	Timeout time.Duration
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewPostgresStore(db *sql.DB, timeout time.Duration) *PostgresStore {
+	return &PostgresStore{DB: db, Timeout: timeout, lastSweep: time.Now()}
+}
+
+// Take counts the request with a single upsert, which is atomic even when several instances
+// count against the same key at once. A row left over from an earlier window is reset to a
+// count of one rather than incremented.
+func (s *PostgresStore) Take(ctx context.Context, key string, limit int,
+	window time.Duration) (Result, error) {
+	start := windowStart(time.Now(), window)
+
+	query := `
+		INSERT INTO quotas (key, window_start, count)
+		VALUES ($1, $2, 1)
+		ON CONFLICT (key) DO UPDATE
+		SET count = CASE WHEN quotas.window_start = EXCLUDED.window_start
+				THEN quotas.count + 1 ELSE 1 END,
+			window_start = EXCLUDED.window_start
+		RETURNING count`
+
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	var count int
+
+	err := s.DB.QueryRowContext(ctx, query, key, start).Scan(&count)
+	if err != nil {
+		return Result{}, err
+	}
+
+	err = s.sweep(ctx, start, window)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return newResult(count, limit, start, window), nil
+}
+
+// sweep deletes the rows for windows which have ended, at most once per window on each
+// instance, so that the rows of clients which are no longer active don't build up in the
+// quotas table.
+func (s *PostgresStore) sweep(ctx context.Context, start time.Time, window time.Duration) error {
+	s.mu.Lock()
+	if time.Since(s.lastSweep) <= window {
+		s.mu.Unlock()
+		return nil
+	}
+	s.lastSweep = time.Now()
+	s.mu.Unlock()
+
+	_, err := s.DB.ExecContext(ctx, "DELETE FROM quotas WHERE window_start < $1", start)
+	return err
+}
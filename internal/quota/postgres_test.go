@@ -0,0 +1,173 @@
+package quota
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// windowStartArg is a sqlmock argument matcher which matches the start of the current window.
+type windowStartArg struct {
+	window time.Duration
+}
+
+func (a windowStartArg) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && t.Equal(t.Truncate(a.window)) && time.Since(t) < a.window
+}
+
+func newTestPostgresStore(t *testing.T) (*PostgresStore, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+
+	return NewPostgresStore(db, time.Second), mock
+}
+
+func TestPostgresStoreTake(t *testing.T) {
+	window := time.Hour
+
+	tests := []struct {
+		name          string
+		count         int
+		wantRemaining int
+		wantAllowed   bool
+	}{
+		// A count of one is returned both for a new key and for a key whose row is left over
+		// from an earlier window, which the upsert resets.
+		{"First request in window", 1, 2, true},
+		{"Last allowed request", 3, 0, true},
+		{"Over the limit", 4, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newTestPostgresStore(t)
+
+			mock.ExpectQuery(regexp.QuoteMeta("ON CONFLICT (key) DO UPDATE")).
+				WithArgs("user:1", windowStartArg{window}).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tt.count))
+
+			result, err := s.Take(context.Background(), "user:1", 3, window)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.Limit != 3 || result.Remaining != tt.wantRemaining ||
+				result.Allowed != tt.wantAllowed {
+				t.Errorf("got %+v; want limit 3, remaining %d and allowed %t", result,
+					tt.wantRemaining, tt.wantAllowed)
+			}
+
+			if want := windowStart(time.Now(), window).Add(window); !result.Reset.Equal(want) {
+				t.Errorf("got reset %v; want %v", result.Reset, want)
+			}
+		})
+	}
+}
+
+func TestPostgresStoreWindowReset(t *testing.T) {
+	// The count is only incremented when the stored row belongs to the window being counted,
+	// otherwise it starts again at one along with the new window start.
+	s, mock := newTestPostgresStore(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SET count = CASE WHEN quotas.window_start = `+
+		`EXCLUDED.window_start THEN quotas.count + 1 ELSE 1 END, `+
+		`window_start = EXCLUDED.window_start`)).
+		WithArgs("ip:192.0.2.1", windowStartArg{time.Minute}).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	result, err := s.Take(context.Background(), "ip:192.0.2.1", 5, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Remaining != 4 || !result.Allowed {
+		t.Errorf("got %+v; want 4 remaining and allowed", result)
+	}
+}
+
+func TestPostgresStoreSweep(t *testing.T) {
+	window := time.Hour
+	s, mock := newTestPostgresStore(t)
+
+	expectTake := func() {
+		mock.ExpectQuery("INSERT INTO quotas").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	}
+
+	take := func() {
+		t.Helper()
+
+		_, err := s.Take(context.Background(), "user:1", 10, window)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A new store doesn't sweep until a window has passed.
+	expectTake()
+	take()
+
+	// Once a window has passed the rows of earlier windows are deleted...
+	s.lastSweep = time.Now().Add(-window - time.Second)
+	expectTake()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM quotas WHERE window_start < $1")).
+		WithArgs(windowStartArg{window}).
+		WillReturnResult(sqlmock.NewResult(0, 12))
+	take()
+
+	// ...but not again until another window has passed.
+	expectTake()
+	take()
+}
+
+func TestPostgresStoreErrors(t *testing.T) {
+	t.Run("Sweep", func(t *testing.T) {
+		s, mock := newTestPostgresStore(t)
+		s.lastSweep = time.Time{}
+
+		mock.ExpectQuery("INSERT INTO quotas").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectExec("DELETE FROM quotas").WillReturnError(errors.New("connection reset"))
+
+		_, err := s.Take(context.Background(), "user:1", 10, time.Hour)
+		if err == nil {
+			t.Error("got nil error; want the sweep error")
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		s, mock := newTestPostgresStore(t)
+		s.Timeout = 10 * time.Millisecond
+
+		mock.ExpectQuery("INSERT INTO quotas").
+			WillDelayFor(time.Second).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+		start := time.Now()
+
+		_, err := s.Take(context.Background(), "user:1", 10, time.Hour)
+		if err == nil {
+			t.Error("got nil error; want the query to be canceled")
+		}
+
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("Take() returned after %v; want it to give up after the timeout", elapsed)
+		}
+	})
+}
@@ -0,0 +1,44 @@
+/*
+internal/quota/quota.go
+- The quota package counts requests against fixed-window quotas. Windows
+are aligned to multiples of the window length, so every API instance
+sharing a Store agrees on when a window starts and ends.
+*/
+
+package quota
+
+import (
+	"context"
+	"time"
+)
+
+// Result describes the state of a quota after a request has been counted against it.
+type Result struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+	Allowed   bool
+}
+
+// Store is implemented by anything which can count requests against a quota. Take counts one
+// request against the quota identified by key, which allows limit requests per window. The
+// context is that of the request being counted.
+type Store interface {
+	Take(ctx context.Context, key string, limit int, window time.Duration) (Result, error)
+}
+
+// windowStart returns the start of the window which t falls into.
+func windowStart(t time.Time, window time.Duration) time.Time {
+	return t.Truncate(window)
+}
+
+// newResult builds the Result for a quota which has counted the given number of requests in
+// the window starting at start.
+func newResult(count, limit int, start time.Time, window time.Duration) Result {
+	return Result{
+		Limit:     limit,
+		Remaining: max(limit-count, 0),
+		Reset:     start.Add(window),
+		Allowed:   count <= limit,
+	}
+}
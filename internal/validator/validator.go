@@ -8,7 +8,7 @@ import (
 // EmailRegEx is used for sanity checking the format of email addresses.
 var (
 	EmailRegEx = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@" +
-		"[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\\\.[a-zA-Z0-9]" +
+		"[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9]" +
 		"(?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 )
 
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS movies_title_search_idx ON movies USING GIN (to_tsvector('english', title));
\ No newline at end of file
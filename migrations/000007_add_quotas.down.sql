@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS quotas;
+ALTER TABLE users DROP COLUMN IF EXISTS plan;
\ No newline at end of file
@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS plan text NOT NULL DEFAULT 'free';
+
+CREATE TABLE IF NOT EXISTS quotas (
+    key text PRIMARY KEY,
+    window_start timestamp(0) with time zone NOT NULL,
+    count integer NOT NULL
+);
\ No newline at end of file
@@ -0,0 +1,3 @@
+INSERT INTO permissions (code)
+VALUES ('admin')
+ON CONFLICT (code) DO NOTHING;
\ No newline at end of file
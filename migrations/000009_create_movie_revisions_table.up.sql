@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version integer NOT NULL,
+    snapshot jsonb NOT NULL,
+    changed_fields text[] NOT NULL,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (movie_id, version)
+);
+
+-- Record the current version of every existing movie, so that each movie has a revision to
+-- diff and revert against. Who made these versions and what changed in them isn't known.
+INSERT INTO movie_revisions (movie_id, version, snapshot, changed_fields, created_at)
+SELECT id, version,
+    jsonb_build_object('title', title, 'year', year, 'runtime', runtime, 'genres', genres),
+    '{}', created_at
+FROM movies
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,7 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+-- When movies were deleted before this migration isn't known, so their retention period
+-- starts now.
+UPDATE movies SET deleted_at = NOW() WHERE deleted AND deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS movies_deleted_at_idx ON movies (deleted_at) WHERE deleted;
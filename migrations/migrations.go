@@ -0,0 +1,16 @@
+/*
+migrations/migrations.go
+- The migrations package embeds the paired up and down SQL migration files in
+this directory, so that the api binary can apply them without needing access
+to the source tree.
+*/
+
+package migrations
+
+import "embed"
+
+// FS holds every migration file. Files are named NNNNNN_description.up.sql and
+// NNNNNN_description.down.sql, where NNNNNN is the version number of the migration.
+//
+//go:embed *.sql
+var FS embed.FS